@@ -0,0 +1,149 @@
+// Package bsdiff creates and applies binary patches in the BSDIFF40 format of
+// Colin Percival's bsdiff, which osu! used to update packages between revisions.
+//
+// A patch consists of a 32 byte header and three bzip2 compressed streams: the
+// control triples, the bytes to add to the old data and the extra bytes to insert.
+package bsdiff
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrCorruptPatch is returned for patches that are not valid BSDIFF40 patches
+var ErrCorruptPatch = errors.New("corrupt patch")
+
+// magic identifies BSDIFF40 patches
+const magic = "BSDIFF40"
+
+// headerSize is the size of the patch header
+const headerSize = 32
+
+// Diff returns a patch that turns oldData into newData. It needs memory of
+// about 16 times the size of oldData for the suffix array.
+func Diff(oldData, newData []byte) []byte {
+	I := qsufsort(oldData)
+
+	var ctrl, diff, extra []byte
+	var scan, pos, length int
+	var lastScan, lastPos, lastOffset int
+
+	for scan < len(newData) {
+		oldScore := 0
+		scan += length
+		for scsc := scan; scan < len(newData); scan++ {
+			pos, length = search(I, oldData, newData[scan:])
+
+			for ; scsc < scan+length; scsc++ {
+				if o := scsc + lastOffset; o >= 0 && o < len(oldData) && oldData[o] == newData[scsc] {
+					oldScore++
+				}
+			}
+			if (length == oldScore && length != 0) || length > oldScore+8 {
+				break
+			}
+			if o := scan + lastOffset; o >= 0 && o < len(oldData) && oldData[o] == newData[scan] {
+				oldScore--
+			}
+		}
+
+		if length == oldScore && scan != len(newData) {
+			continue
+		}
+
+		// Extend the previous match forwards
+		s, best, lengthForward := 0, 0, 0
+		for i := 0; lastScan+i < scan && lastPos+i < len(oldData); {
+			if oldData[lastPos+i] == newData[lastScan+i] {
+				s++
+			}
+			i++
+			if s*2-i > best*2-lengthForward {
+				best = s
+				lengthForward = i
+			}
+		}
+
+		// Extend the next match backwards
+		lengthBackward := 0
+		if scan < len(newData) {
+			s, best := 0, 0
+			for i := 1; scan >= lastScan+i && pos >= i; i++ {
+				if oldData[pos-i] == newData[scan-i] {
+					s++
+				}
+				if s*2-i > best*2-lengthBackward {
+					best = s
+					lengthBackward = i
+				}
+			}
+		}
+
+		// Split overlapping extensions where the most bytes match
+		if lastScan+lengthForward > scan-lengthBackward {
+			overlap := (lastScan + lengthForward) - (scan - lengthBackward)
+			s, best, lengthSplit := 0, 0, 0
+			for i := 0; i < overlap; i++ {
+				if newData[lastScan+lengthForward-overlap+i] == oldData[lastPos+lengthForward-overlap+i] {
+					s++
+				}
+				if newData[scan-lengthBackward+i] == oldData[pos-lengthBackward+i] {
+					s--
+				}
+				if s > best {
+					best = s
+					lengthSplit = i + 1
+				}
+			}
+			lengthForward += lengthSplit - overlap
+			lengthBackward -= lengthSplit
+		}
+
+		for i := 0; i < lengthForward; i++ {
+			diff = append(diff, newData[lastScan+i]-oldData[lastPos+i])
+		}
+		extraLength := (scan - lengthBackward) - (lastScan + lengthForward)
+		extra = append(extra, newData[lastScan+lengthForward:lastScan+lengthForward+extraLength]...)
+
+		ctrl = appendOffset(ctrl, int64(lengthForward))
+		ctrl = appendOffset(ctrl, int64(extraLength))
+		ctrl = appendOffset(ctrl, int64((pos-lengthBackward)-(lastPos+lengthForward)))
+
+		lastScan = scan - lengthBackward
+		lastPos = pos - lengthBackward
+		lastOffset = pos - scan
+	}
+
+	ctrlStream := compressBzip2(ctrl)
+	diffStream := compressBzip2(diff)
+	extraStream := compressBzip2(extra)
+
+	patch := make([]byte, 0, headerSize+len(ctrlStream)+len(diffStream)+len(extraStream))
+	patch = append(patch, magic...)
+	patch = appendOffset(patch, int64(len(ctrlStream)))
+	patch = appendOffset(patch, int64(len(diffStream)))
+	patch = appendOffset(patch, int64(len(newData)))
+	patch = append(patch, ctrlStream...)
+	patch = append(patch, diffStream...)
+	return append(patch, extraStream...)
+}
+
+// appendOffset appends a signed 64-bit integer in the sign-magnitude
+// little-endian encoding of bsdiff
+func appendOffset(data []byte, value int64) []byte {
+	magnitude := uint64(value)
+	if value < 0 {
+		magnitude = uint64(-value) | 1<<63
+	}
+	return binary.LittleEndian.AppendUint64(data, magnitude)
+}
+
+// readOffset reads a signed 64-bit integer in the encoding of appendOffset
+func readOffset(data []byte) int64 {
+	magnitude := binary.LittleEndian.Uint64(data)
+	value := int64(magnitude &^ (1 << 63))
+	if magnitude&(1<<63) != 0 {
+		return -value
+	}
+	return value
+}
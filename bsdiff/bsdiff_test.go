@@ -0,0 +1,158 @@
+package bsdiff
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// randomBytes returns n pseudo-random bytes, determined by the seed
+func randomBytes(n int, seed int64) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// TestCompressBzip2 checks that the encoder output is decoded by compress/bzip2
+func TestCompressBzip2(t *testing.T) {
+	allBytes := make([]byte, 256)
+	for i := range allBytes {
+		allBytes[i] = byte(i)
+	}
+
+	// Runs at the limits of the initial run-length encoding
+	var runs []byte
+	for _, n := range []int{1, 3, 4, 5, 254, 255, 256, 258, 259, 260, 1000} {
+		runs = append(runs, bytes.Repeat([]byte{byte(n)}, n)...)
+	}
+
+	// More than one block, with runs crossing the block boundary
+	blocks := append(randomBytes(bzip2BlockSize-2, 1), bytes.Repeat([]byte{7}, 300)...)
+	blocks = append(blocks, randomBytes(100000, 2)...)
+
+	tests := map[string][]byte{
+		"empty":      nil,
+		"single":     {42},
+		"text":       []byte("The quick brown fox jumps over the lazy dog"),
+		"all bytes":  allBytes,
+		"zeros":      make([]byte, 100000),
+		"runs":       runs,
+		"periodic":   bytes.Repeat([]byte("ab"), 50000),
+		"random":     randomBytes(200000, 3),
+		"two blocks": blocks,
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			compressed := compressBzip2(data)
+			decompressed, err := io.ReadAll(bzip2.NewReader(bytes.NewReader(compressed)))
+			if err != nil {
+				t.Fatalf("Failed to decompress: %v", err)
+			}
+			if !bytes.Equal(decompressed, data) {
+				t.Fatalf("Decompressed %d bytes, expected %d", len(decompressed), len(data))
+			}
+		})
+	}
+}
+
+// TestDiffPatch checks that patches created by Diff reproduce the new data
+func TestDiffPatch(t *testing.T) {
+	old := randomBytes(100000, 4)
+
+	// Insert, remove and change bytes in a copy
+	edited := append([]byte{}, old[:1000]...)
+	edited = append(edited, []byte("inserted data")...)
+	edited = append(edited, old[1000:50000]...)
+	edited = append(edited, old[60000:]...)
+	for i := 20000; i < 20100; i++ {
+		edited[i] ^= 0x01
+	}
+
+	tests := []struct {
+		name     string
+		old, new []byte
+	}{
+		{"both empty", nil, nil},
+		{"old empty", nil, []byte("new data")},
+		{"new empty", []byte("old data"), nil},
+		{"identical", old, old},
+		{"edited", old, edited},
+		{"unrelated", old[:5000], randomBytes(5000, 5)},
+		{"repetitive", bytes.Repeat([]byte("abc"), 1000), bytes.Repeat([]byte("abcd"), 1000)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch := Diff(test.old, test.new)
+			if !bytes.HasPrefix(patch, []byte(magic)) {
+				t.Fatalf("Patch does not start with %s", magic)
+			}
+
+			patched, err := Patch(test.old, patch)
+			if err != nil {
+				t.Fatalf("Failed to apply patch: %v", err)
+			}
+			if !bytes.Equal(patched, test.new) {
+				t.Fatal("Patched data differs from the new data")
+			}
+		})
+	}
+
+	// Small edits result in small patches
+	if patch := Diff(old, edited); len(patch) > 2000 {
+		t.Errorf("Patch for a small edit has %d bytes", len(patch))
+	}
+}
+
+// TestPatchReference applies a patch whose streams were compressed by the
+// reference bzip2 implementation
+func TestPatchReference(t *testing.T) {
+	patch, _ := hex.DecodeString("425344494646343030000000000000002b000000000000002a00000000000000" +
+		"425a683931415926535978c8b7de00000de0004e1800202000310c00c1327696d24c40219bf17724538509078c8b7de0" +
+		"425a68393141592653593648556e0000026001400000044000200021981984e890bb9229c28481b242ab70" +
+		"425a68393141592653590f860bfa000002918020002e0014002000220d33421803dc2145dc914e142403e182fe80")
+
+	patched, err := Patch([]byte("The quick brown fox jumps over the lazy dog"), patch)
+	if err != nil {
+		t.Fatalf("Failed to apply patch: %v", err)
+	}
+	if expected := "THE quick red fox jumps over the lazy cat!"; string(patched) != expected {
+		t.Errorf("Got %q, expected %q", patched, expected)
+	}
+}
+
+// TestCorruptPatch checks that invalid patches are rejected
+func TestCorruptPatch(t *testing.T) {
+	old := []byte("The quick brown fox jumps over the lazy dog")
+	patch := Diff(old, []byte("The quick red fox jumps over the lazy cat"))
+
+	negativeSize := bytes.Clone(patch)
+	negativeSize[31] |= 0x80
+	largeSize := bytes.Clone(patch)
+	largeSize[30] = 0x7f
+	damagedStream := bytes.Clone(patch)
+	damagedStream[headerSize+20] ^= 0xff
+
+	tests := map[string][]byte{
+		"empty":          nil,
+		"bad magic":      append([]byte("BSDIFF41"), patch[8:]...),
+		"header only":    patch[:headerSize],
+		"truncated":      patch[:len(patch)-10],
+		"negative size":  negativeSize,
+		"size too large": largeSize,
+		"damaged stream": damagedStream,
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Patch(old, data); !errors.Is(err, ErrCorruptPatch) {
+				t.Errorf("Expected %v, got %v", ErrCorruptPatch, err)
+			}
+		})
+	}
+}
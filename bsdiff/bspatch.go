@@ -0,0 +1,97 @@
+package bsdiff
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"fmt"
+	"io"
+)
+
+const (
+	// maxPreallocation limits the memory allocated up front for the
+	// patched data, as the size in the header is not trusted
+	maxPreallocation = 64 << 20
+
+	// maxPosition bounds the position in the old data, which
+	// keeps the arithmetic on it from overflowing
+	maxPosition = 1 << 60
+)
+
+// Patch applies a patch created by Diff, or by any other BSDIFF40
+// implementation, to oldData and returns the new data
+func Patch(oldData, patch []byte) ([]byte, error) {
+	if len(patch) < headerSize || string(patch[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: missing BSDIFF40 header", ErrCorruptPatch)
+	}
+	ctrlLength := readOffset(patch[8:])
+	diffLength := readOffset(patch[16:])
+	newSize := readOffset(patch[24:])
+
+	available := int64(len(patch) - headerSize)
+	if ctrlLength < 0 || diffLength < 0 || newSize < 0 || ctrlLength > available || diffLength > available-ctrlLength {
+		return nil, fmt.Errorf("%w: invalid header", ErrCorruptPatch)
+	}
+
+	streams := patch[headerSize:]
+	ctrl := bzip2.NewReader(bytes.NewReader(streams[:ctrlLength]))
+	diff := bzip2.NewReader(bytes.NewReader(streams[ctrlLength : ctrlLength+diffLength]))
+	extra := bzip2.NewReader(bytes.NewReader(streams[ctrlLength+diffLength:]))
+
+	var newData bytes.Buffer
+	newData.Grow(int(min(newSize, maxPreallocation)))
+
+	var oldPos, newPos int64
+	var triple [24]byte
+	for newPos < newSize {
+		if _, err := io.ReadFull(ctrl, triple[:]); err != nil {
+			return nil, fmt.Errorf("%w: control stream: %v", ErrCorruptPatch, err)
+		}
+		addLength := readOffset(triple[0:])
+		extraLength := readOffset(triple[8:])
+		seek := readOffset(triple[16:])
+
+		// Add the old data to the diff bytes
+		if addLength < 0 || addLength > newSize-newPos {
+			return nil, fmt.Errorf("%w: invalid diff length %d", ErrCorruptPatch, addLength)
+		}
+		start := newData.Len()
+		if _, err := io.CopyN(&newData, diff, addLength); err != nil {
+			return nil, fmt.Errorf("%w: diff stream: %v", ErrCorruptPatch, err)
+		}
+		added := newData.Bytes()[start:]
+		from := max(0, -oldPos)
+		to := min(int64(len(added)), int64(len(oldData))-oldPos)
+		for i := from; i < to; i++ {
+			added[i] += oldData[oldPos+i]
+		}
+		newPos += addLength
+		oldPos += addLength
+
+		// Insert the extra bytes
+		if extraLength < 0 || extraLength > newSize-newPos {
+			return nil, fmt.Errorf("%w: invalid extra length %d", ErrCorruptPatch, extraLength)
+		}
+		if _, err := io.CopyN(&newData, extra, extraLength); err != nil {
+			return nil, fmt.Errorf("%w: extra stream: %v", ErrCorruptPatch, err)
+		}
+		newPos += extraLength
+
+		if seek < -maxPosition || seek > maxPosition {
+			return nil, fmt.Errorf("%w: invalid seek %d", ErrCorruptPatch, seek)
+		}
+		oldPos += seek
+		if oldPos < -maxPosition || oldPos > maxPosition {
+			return nil, fmt.Errorf("%w: seek beyond the old data", ErrCorruptPatch)
+		}
+	}
+
+	// Reading to the end verifies the checksums of the streams
+	for _, stream := range []io.Reader{ctrl, diff, extra} {
+		var b [1]byte
+		if _, err := io.ReadFull(stream, b[:]); err != io.EOF {
+			return nil, fmt.Errorf("%w: streams do not end with the patched data", ErrCorruptPatch)
+		}
+	}
+
+	return newData.Bytes(), nil
+}
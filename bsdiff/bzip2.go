@@ -0,0 +1,358 @@
+package bsdiff
+
+import "sort"
+
+// The standard library only implements bzip2 decompression, so patches are
+// compressed with this minimal encoder. It produces valid streams for any
+// bzip2 decoder, but uses a single Huffman table per block, which compresses
+// less than the reference implementation.
+
+const (
+	// bzip2BlockSize is the largest block of level 9, as used by bzip2
+	bzip2BlockSize = 900000 - 19
+
+	// bzip2GroupSize is the number of symbols coded with the same table
+	bzip2GroupSize = 50
+
+	// bzip2MaxCodeLength is the longest Huffman code bzip2 generates
+	bzip2MaxCodeLength = 17
+)
+
+// compressBzip2 compresses data as a bzip2 stream
+func compressBzip2(data []byte) []byte {
+	w := &bitWriter{}
+	for _, b := range []byte("BZh9") {
+		w.writeBits(8, uint32(b))
+	}
+
+	var combinedCRC uint32
+	for len(data) > 0 {
+		block, n := encodeRuns(data, bzip2BlockSize)
+		crc := bzip2CRC(data[:n])
+		combinedCRC = (combinedCRC<<1 | combinedCRC>>31) ^ crc
+		writeBlock(w, block, crc)
+		data = data[n:]
+	}
+
+	w.writeBits(24, 0x177245)
+	w.writeBits(24, 0x385090)
+	w.writeBits(32, combinedCRC)
+	return w.flush()
+}
+
+// encodeRuns applies the initial run-length encoding of bzip2: runs of 4 to
+// 255 equal bytes are stored as 4 bytes and a count. It returns the encoded
+// block, at most limit bytes, and the number of bytes of data it covers.
+func encodeRuns(data []byte, limit int) ([]byte, int) {
+	var block []byte
+	i := 0
+	for i < len(data) {
+		b := data[i]
+		run := 1
+		for run < 255 && i+run < len(data) && data[i+run] == b {
+			run++
+		}
+
+		if run >= 4 {
+			if len(block)+5 > limit {
+				break
+			}
+			block = append(block, b, b, b, b, byte(run-4))
+		} else {
+			if len(block)+run > limit {
+				break
+			}
+			for j := 0; j < run; j++ {
+				block = append(block, b)
+			}
+		}
+		i += run
+	}
+	return block, i
+}
+
+// writeBlock writes a compressed block
+func writeBlock(w *bitWriter, block []byte, crc uint32) {
+	origPtr, last := transform(block)
+
+	var inUse [256]bool
+	for _, b := range block {
+		inUse[b] = true
+	}
+	symbols, alphaSize := encodeMTF(last, &inUse)
+
+	frequencies := make([]int, alphaSize)
+	for _, symbol := range symbols {
+		frequencies[symbol]++
+	}
+	lengths := huffmanLengths(frequencies, bzip2MaxCodeLength)
+	codes := canonicalCodes(lengths)
+
+	w.writeBits(24, 0x314159)
+	w.writeBits(24, 0x265359)
+	w.writeBits(32, crc)
+	w.writeBits(1, 0) // not randomized
+	w.writeBits(24, uint32(origPtr))
+
+	// Two-level bitmap of the byte values in use
+	var ranges uint32
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 16; j++ {
+			if inUse[i*16+j] {
+				ranges |= 1 << (15 - i)
+			}
+		}
+	}
+	w.writeBits(16, ranges)
+	for i := 0; i < 16; i++ {
+		if ranges&(1<<(15-i)) == 0 {
+			continue
+		}
+		var bits uint32
+		for j := 0; j < 16; j++ {
+			if inUse[i*16+j] {
+				bits |= 1 << (15 - j)
+			}
+		}
+		w.writeBits(16, bits)
+	}
+
+	// bzip2 requires at least two tables; both are the same and every
+	// group selects the first one, which is coded as a single zero bit
+	const tables = 2
+	selectors := (len(symbols) + bzip2GroupSize - 1) / bzip2GroupSize
+	w.writeBits(3, tables)
+	w.writeBits(15, uint32(selectors))
+	for i := 0; i < selectors; i++ {
+		w.writeBits(1, 0)
+	}
+
+	// Code lengths, as differences to the previous length
+	for t := 0; t < tables; t++ {
+		current := lengths[0]
+		w.writeBits(5, uint32(current))
+		for _, length := range lengths {
+			for current < length {
+				w.writeBits(2, 2)
+				current++
+			}
+			for current > length {
+				w.writeBits(2, 3)
+				current--
+			}
+			w.writeBits(1, 0)
+		}
+	}
+
+	for _, symbol := range symbols {
+		w.writeBits(uint(lengths[symbol]), codes[symbol])
+	}
+}
+
+// transform applies the Burrows-Wheeler transform to block. It returns the
+// last column of the sorted rotations and the row of the original block.
+func transform(block []byte) (int, []byte) {
+	n := len(block)
+
+	// The order of the rotations is the order of the suffixes of the
+	// doubled block that start in its first half
+	doubled := make([]byte, 2*n)
+	copy(doubled, block)
+	copy(doubled[n:], block)
+	suffixes := qsufsort(doubled)
+
+	last := make([]byte, 0, n)
+	origPtr := 0
+	for _, i := range suffixes {
+		if i >= n {
+			continue
+		}
+		if i == 0 {
+			origPtr = len(last)
+		}
+		last = append(last, block[(i+n-1)%n])
+	}
+	return origPtr, last
+}
+
+// encodeMTF applies the move-to-front transform to the bytes in use and codes
+// runs of zeros in bijective base 2 with the RUNA and RUNB symbols. It returns
+// the symbols, ending with the end of block symbol, and the alphabet size.
+func encodeMTF(data []byte, inUse *[256]bool) ([]uint16, int) {
+	var order []byte
+	var index [256]byte
+	for b := 0; b < 256; b++ {
+		if inUse[b] {
+			index[b] = byte(len(order))
+			order = append(order, byte(len(order)))
+		}
+	}
+	endOfBlock := uint16(len(order) + 1)
+
+	symbols := make([]uint16, 0, len(data)+1)
+	zeros := 0
+	flushZeros := func() {
+		if zeros == 0 {
+			return
+		}
+		zeros--
+		for {
+			symbols = append(symbols, uint16(zeros&1)) // RUNA or RUNB
+			if zeros < 2 {
+				break
+			}
+			zeros = (zeros - 2) / 2
+		}
+		zeros = 0
+	}
+
+	for _, b := range data {
+		value := index[b]
+		j := 0
+		for order[j] != value {
+			j++
+		}
+		if j == 0 {
+			zeros++
+			continue
+		}
+		flushZeros()
+		copy(order[1:j+1], order[:j])
+		order[0] = value
+		symbols = append(symbols, uint16(j+1))
+	}
+	flushZeros()
+
+	return append(symbols, endOfBlock), len(order) + 2
+}
+
+// huffmanLengths returns Huffman code lengths of at most maxLength bits for
+// the given frequencies. Every symbol gets a code, as bzip2 requires. Like
+// bzip2, the frequencies are flattened until the codes are short enough.
+func huffmanLengths(frequencies []int, maxLength int) []uint8 {
+	n := len(frequencies)
+	weights := make([]int, n)
+	for i, frequency := range frequencies {
+		weights[i] = max(frequency, 1)
+	}
+
+	lengths := make([]uint8, n)
+	for {
+		leaves := make([]int, n)
+		for i := range leaves {
+			leaves[i] = i
+		}
+		sort.SliceStable(leaves, func(a, b int) bool {
+			return weights[leaves[a]] < weights[leaves[b]]
+		})
+
+		// Two-queue construction: the internal nodes are created
+		// in ascending weight order after the sorted leaves
+		nodeWeights := append(make([]int, 0, 2*n-1), weights...)
+		parents := make([]int, 2*n-1)
+		next, internal := 0, n
+		pick := func() int {
+			if next < n && (internal >= len(nodeWeights) || nodeWeights[leaves[next]] <= nodeWeights[internal]) {
+				next++
+				return leaves[next-1]
+			}
+			internal++
+			return internal - 1
+		}
+		for len(nodeWeights) < 2*n-1 {
+			a, b := pick(), pick()
+			parents[a] = len(nodeWeights)
+			parents[b] = len(nodeWeights)
+			nodeWeights = append(nodeWeights, nodeWeights[a]+nodeWeights[b])
+		}
+
+		// Parents always come after their children
+		depths := make([]int, 2*n-1)
+		longest := 0
+		for i := 2*n - 3; i >= 0; i-- {
+			depths[i] = depths[parents[i]] + 1
+			if i < n {
+				longest = max(longest, depths[i])
+			}
+		}
+		if longest <= maxLength {
+			for i := range lengths {
+				lengths[i] = uint8(depths[i])
+			}
+			return lengths
+		}
+
+		for i := range weights {
+			weights[i] = 1 + weights[i]/2
+		}
+	}
+}
+
+// canonicalCodes assigns the codes of bzip2 to the code lengths: shorter
+// codes first, and symbols of the same length in ascending order
+func canonicalCodes(lengths []uint8) []uint32 {
+	codes := make([]uint32, len(lengths))
+	var code uint32
+	for length := uint8(1); length <= bzip2MaxCodeLength; length++ {
+		for symbol, l := range lengths {
+			if l == length {
+				codes[symbol] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// bzip2CRCTable is the table of the big-endian CRC-32 used by bzip2
+var bzip2CRCTable [256]uint32
+
+func init() {
+	for i := range bzip2CRCTable {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		bzip2CRCTable[i] = crc
+	}
+}
+
+// bzip2CRC returns the CRC of a block
+func bzip2CRC(data []byte) uint32 {
+	crc := ^uint32(0)
+	for _, b := range data {
+		crc = bzip2CRCTable[byte(crc>>24)^b] ^ crc<<8
+	}
+	return ^crc
+}
+
+// bitWriter writes bits, most significant first
+type bitWriter struct {
+	data  []byte
+	bits  uint64
+	count uint
+}
+
+// writeBits writes the lowest n bits of value, n being at most 32
+func (w *bitWriter) writeBits(n uint, value uint32) {
+	w.bits = w.bits<<n | uint64(value)&(1<<n-1)
+	w.count += n
+	for w.count >= 8 {
+		w.count -= 8
+		w.data = append(w.data, byte(w.bits>>w.count))
+	}
+}
+
+// flush pads the last byte with zeros and returns the written data
+func (w *bitWriter) flush() []byte {
+	if w.count > 0 {
+		w.data = append(w.data, byte(w.bits<<(8-w.count)))
+		w.count = 0
+	}
+	return w.data
+}
@@ -0,0 +1,174 @@
+package bsdiff
+
+import "bytes"
+
+// qsufsort returns the suffix array of data, using the Larsson-Sadakane
+// algorithm of the original bsdiff. The array has len(data)+1 entries,
+// the first being the empty suffix.
+func qsufsort(data []byte) []int {
+	n := len(data)
+	I := make([]int, n+1)
+	V := make([]int, n+1)
+
+	var buckets [256]int
+	for _, c := range data {
+		buckets[c]++
+	}
+	for i := 1; i < 256; i++ {
+		buckets[i] += buckets[i-1]
+	}
+	copy(buckets[1:], buckets[:255])
+	buckets[0] = 0
+
+	for i, c := range data {
+		buckets[c]++
+		I[buckets[c]] = i
+	}
+	I[0] = n
+	for i, c := range data {
+		V[i] = buckets[c]
+	}
+	V[n] = 0
+	for i := 1; i < 256; i++ {
+		if buckets[i] == buckets[i-1]+1 {
+			I[buckets[i]] = -1
+		}
+	}
+	I[0] = -1
+
+	for h := 1; I[0] != -(n + 1); h += h {
+		length := 0
+		i := 0
+		for i < n+1 {
+			if I[i] < 0 {
+				length -= I[i]
+				i -= I[i]
+				continue
+			}
+			if length != 0 {
+				I[i-length] = -length
+			}
+			length = V[I[i]] + 1 - i
+			split(I, V, i, length, h)
+			i += length
+			length = 0
+		}
+		if length != 0 {
+			I[i-length] = -length
+		}
+	}
+
+	for i := 0; i < n+1; i++ {
+		I[V[i]] = i
+	}
+	return I
+}
+
+// split sorts the group of suffixes I[start:start+length] by their rank at offset h
+func split(I, V []int, start, length, h int) {
+	if length < 16 {
+		for k := start; k < start+length; {
+			j := 1
+			x := V[I[k]+h]
+			for i := 1; k+i < start+length; i++ {
+				if V[I[k+i]+h] < x {
+					x = V[I[k+i]+h]
+					j = 0
+				}
+				if V[I[k+i]+h] == x {
+					I[k+j], I[k+i] = I[k+i], I[k+j]
+					j++
+				}
+			}
+			for i := 0; i < j; i++ {
+				V[I[k+i]] = k + j - 1
+			}
+			if j == 1 {
+				I[k] = -1
+			}
+			k += j
+		}
+		return
+	}
+
+	x := V[I[start+length/2]+h]
+	jj, kk := 0, 0
+	for i := start; i < start+length; i++ {
+		if V[I[i]+h] < x {
+			jj++
+		}
+		if V[I[i]+h] == x {
+			kk++
+		}
+	}
+	jj += start
+	kk += jj
+
+	i, j, k := start, 0, 0
+	for i < jj {
+		switch {
+		case V[I[i]+h] < x:
+			i++
+		case V[I[i]+h] == x:
+			I[i], I[jj+j] = I[jj+j], I[i]
+			j++
+		default:
+			I[i], I[kk+k] = I[kk+k], I[i]
+			k++
+		}
+	}
+	for jj+j < kk {
+		if V[I[jj+j]+h] == x {
+			j++
+		} else {
+			I[jj+j], I[kk+k] = I[kk+k], I[jj+j]
+			k++
+		}
+	}
+
+	if jj > start {
+		split(I, V, start, jj-start, h)
+	}
+	for i := 0; i < kk-jj; i++ {
+		V[I[jj+i]] = kk - 1
+	}
+	if jj == kk-1 {
+		I[jj] = -1
+	}
+	if start+length > kk {
+		split(I, V, kk, start+length-kk, h)
+	}
+}
+
+// search returns the position and length of the longest match
+// of target in data, using the suffix array I of data
+func search(I []int, data, target []byte) (pos, length int) {
+	start, end := 0, len(data)
+	for end-start >= 2 {
+		middle := start + (end-start)/2
+		n := min(len(data)-I[middle], len(target))
+		if bytes.Compare(data[I[middle]:I[middle]+n], target[:n]) < 0 {
+			start = middle
+		} else {
+			end = middle
+		}
+	}
+
+	x := matchLength(data[I[start]:], target)
+	y := matchLength(data[I[end]:], target)
+	if x > y {
+		return I[start], x
+	}
+	return I[end], y
+}
+
+// matchLength returns the length of the common prefix of a and b
+func matchLength(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
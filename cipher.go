@@ -0,0 +1,107 @@
+package osz2
+
+import (
+	"crypto/cipher"
+)
+
+// Cipher is implemented by the osz2 ciphers (XTEA, XXTEA and SimpleCryptor).
+// Encrypt and Decrypt transform count bytes of buffer in place, starting at start.
+// The ciphers are not length-preserving blocks of a fixed size: how a buffer is
+// split into calls changes the output, which is why streams built on top of them
+// (e.g. XXTEAReader) encrypt every read or write as its own chunk.
+type Cipher interface {
+	Encrypt(buffer []byte, start, count int)
+	Decrypt(buffer []byte, start, count int)
+}
+
+var (
+	_ Cipher = (*XTEA)(nil)
+	_ Cipher = (*XXTEA)(nil)
+	_ Cipher = (*SimpleCryptor)(nil)
+)
+
+const (
+	// XTEABlockSize is the size of a single XTEA word
+	XTEABlockSize = 8
+	// XXTEABlockSize is the size of the blocks osz2 file bodies are encrypted in
+	XXTEABlockSize = MaxBytes
+)
+
+// NewBlock adapts a Cipher to the cipher.Block interface, so it can be used
+// with the block modes of crypto/cipher. Every call processes exactly blockSize
+// bytes, e.g. XTEABlockSize for XTEA or XXTEABlockSize for XXTEA.
+func NewBlock(c Cipher, blockSize int) cipher.Block {
+	if blockSize <= 0 {
+		panic("osz2: invalid block size")
+	}
+	return &block{cipher: c, size: blockSize}
+}
+
+// block implements cipher.Block on top of a Cipher
+type block struct {
+	cipher Cipher
+	size   int
+}
+
+// BlockSize returns the block size of the adapter
+func (b *block) BlockSize() int {
+	return b.size
+}
+
+// Encrypt encrypts the first block in src into dst
+func (b *block) Encrypt(dst, src []byte) {
+	b.check(dst, src)
+	copy(dst, src[:b.size])
+	b.cipher.Encrypt(dst, 0, b.size)
+}
+
+// Decrypt decrypts the first block in src into dst
+func (b *block) Decrypt(dst, src []byte) {
+	b.check(dst, src)
+	copy(dst, src[:b.size])
+	b.cipher.Decrypt(dst, 0, b.size)
+}
+
+// check validates the buffer sizes like the crypto/cipher implementations do
+func (b *block) check(dst, src []byte) {
+	if len(src) < b.size {
+		panic("osz2: input not full block")
+	}
+	if len(dst) < b.size {
+		panic("osz2: output not full block")
+	}
+}
+
+// NewEncryptStream returns a cipher.Stream that encrypts with c. Every call
+// to XORKeyStream is encrypted as one chunk, which matches a single Write on
+// C#'s XXTeaStream. Combined with cipher.StreamWriter this gives a writer that
+// produces the same output as osu!'s BinaryWriter on top of an XXTeaStream.
+func NewEncryptStream(c Cipher) cipher.Stream {
+	return &stream{cipher: c, encrypt: true}
+}
+
+// NewDecryptStream returns a cipher.Stream that decrypts with c. Every call
+// to XORKeyStream is decrypted as one chunk, so cipher.StreamReader on top of
+// it behaves exactly like XXTEAReader.
+func NewDecryptStream(c Cipher) cipher.Stream {
+	return &stream{cipher: c}
+}
+
+// stream implements cipher.Stream on top of a Cipher
+type stream struct {
+	cipher  Cipher
+	encrypt bool
+}
+
+// XORKeyStream transforms src into dst as a single chunk
+func (s *stream) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("osz2: output smaller than input")
+	}
+	copy(dst, src)
+	if s.encrypt {
+		s.cipher.Encrypt(dst, 0, len(src))
+	} else {
+		s.cipher.Decrypt(dst, 0, len(src))
+	}
+}
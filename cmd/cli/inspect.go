@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Lekuruu/osz2-go"
+)
+
+// runInspect runs the inspect subcommand, which prints the layout of a package
+func runInspect(args []string) int {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	key := flags.String("key", "", "Hex encoded key to inspect the package with, instead of deriving it")
+	flags.Usage = func() {
+		fmt.Println("Usage:")
+		fmt.Println("  osz2-cli inspect [-key <hex>] <file.osz2>")
+		fmt.Println()
+		fmt.Println("Flags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	var options []osz2.Option
+	if *key != "" {
+		keyBytes, err := hex.DecodeString(*key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid key: %v\n", err)
+			return 1
+		}
+		options = append(options, osz2.WithKey(keyBytes))
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening file: %v\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	inspection, err := osz2.Inspect(file, options...)
+	printInspection(inspection)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nInspection stopped: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// printInspection prints every section of an inspected package
+func printInspection(inspection *osz2.Inspection) {
+	fmt.Printf("Size:              %d bytes\n", inspection.Size)
+	fmt.Printf("Identifier:        %x\n", inspection.Identifier)
+	fmt.Printf("Version:           %d\n", inspection.Version)
+	fmt.Printf("IV:                %x\n", inspection.IV)
+	printHash("Metadata hash", inspection.MetaDataHash)
+	printHash("File info hash", inspection.FileInfoHash)
+	printHash("Body hash", inspection.FullBodyHash)
+
+	fmt.Printf("\nMetadata at %d (%d entries)\n", inspection.MetadataOffset, len(inspection.Metadata))
+	for _, entry := range inspection.Metadata {
+		fmt.Printf("  %8d  %-14s %q\n", entry.Offset, entry.Type, entry.Value)
+	}
+
+	fmt.Printf("\nFile names at %d (%d entries)\n", inspection.FileNamesOffset, len(inspection.FileNames))
+	for _, entry := range inspection.FileNames {
+		fmt.Printf("  %8d  %10d  %q\n", entry.Offset, entry.BeatmapID, entry.FileName)
+	}
+
+	if inspection.Magic == nil {
+		return
+	}
+	fmt.Printf("\nMagic at %d\n", inspection.MagicOffset)
+	fmt.Printf("  Encrypted:       %x\n", inspection.Magic)
+	fmt.Printf("  Decrypted:       %x\n", inspection.DecryptedMagic)
+	fmt.Printf("  Key:             %x (valid: %v)\n", inspection.Key, inspection.KeyValid)
+
+	if inspection.FileInfoLengthOffset == 0 {
+		return
+	}
+	fmt.Printf("\nFile info length at %d\n", inspection.FileInfoLengthOffset)
+	fmt.Printf("  Encoded:         %d\n", inspection.EncodedFileInfoLength)
+	fmt.Printf("  Decoded:         %d\n", inspection.FileInfoLength)
+
+	if !inspection.KeyValid {
+		fmt.Printf("\nFile info at %d, data at %d (not decrypted without a valid key)\n", inspection.FileInfoOffset, inspection.DataOffset)
+		return
+	}
+	fmt.Printf("\nFile info at %d (%d entries), data at %d\n", inspection.FileInfoOffset, len(inspection.Entries), inspection.DataOffset)
+	fmt.Printf("  %10s  %10s  %10s  %10s  %-32s  %s\n", "Offset", "Absolute", "Table", "Prefix", "Hash", "Name")
+	for _, entry := range inspection.Entries {
+		fmt.Printf(
+			"  %10d  %10d  %10d  %10d  %x  %q\n",
+			entry.Offset, entry.AbsoluteOffset, entry.TableSize, entry.PrefixLength, entry.Hash, entry.FileName,
+		)
+	}
+
+	fmt.Printf("\nTrailing data at %d: %d bytes\n", inspection.TrailingOffset, inspection.TrailingBytes)
+}
+
+// printHash prints a stored hash and whether it matches the computed one
+func printHash(name string, hash osz2.HashCheck) {
+	status := "not computed"
+	if hash.Computed != nil {
+		status = "ok"
+		if !hash.Match() {
+			status = fmt.Sprintf("mismatch, computed %x", hash.Computed)
+		}
+	}
+	fmt.Printf("%-18s %x (%s)\n", name+":", hash.Stored, status)
+}
@@ -2,18 +2,25 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/Lekuruu/osz2-go"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "inspect" {
+		os.Exit(runInspect(os.Args[2:]))
+	}
+
 	inputFile := flag.String("input", "", "Path to the .osz2 file (required)")
 	outputDir := flag.String("output", "", "Output directory for extracted files (required)")
 	metadataFile := flag.String("metadata", "metadata.json", "Output path for metadata JSON file")
+	salvage := flag.Bool("salvage", false, "Extract the intact files of a damaged package")
 	help := flag.Bool("help", false, "Show help message")
 	flag.Parse()
 
@@ -39,11 +46,22 @@ func main() {
 
 	// Parse the osz2 package (metadataOnly => false to read all files)
 	fmt.Println("Reading osz2 package...")
-	pkg, err := osz2.NewPackage(file, false)
+	// Invalid UTF-8 is replaced, so extracted file names and metadata are valid
+	options := []osz2.Option{osz2.WithUTF8Policy(osz2.UTF8Replace)}
+	if *salvage {
+		options = append(options, osz2.WithSalvage())
+	}
+	pkg, err := osz2.NewPackage(file, false, options...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error parsing osz2 package: %v\n", err)
 		os.Exit(1)
 	}
+	for _, warning := range pkg.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", warning)
+	}
+	if pkg.Salvage != nil {
+		printSalvageReport(pkg.Salvage)
+	}
 
 	// Create output directory
 	if err := os.MkdirAll(*outputDir, 0755); err != nil {
@@ -53,8 +71,33 @@ func main() {
 
 	// Extract files
 	fmt.Printf("Extracting %d files to %s...\n", len(pkg.Files), *outputDir)
-	for fileName, content := range pkg.Files {
-		outputPath := filepath.Join(*outputDir, fileName)
+	extracted := make(map[string]string)
+	for _, fileInfo := range pkg.Entries {
+		fileName := fileInfo.FileName
+		if pkg.FileInfos[fileName] != fileInfo {
+			fmt.Fprintf(os.Stderr, "Skipping duplicate entry for %s\n", fileName)
+			continue
+		}
+
+		content, ok := pkg.Files[fileName]
+		if !ok {
+			// Not recovered from a damaged package
+			continue
+		}
+
+		// Names differing only in case or separators are the same file on Windows
+		normalized := osz2.NormalizeName(fileName)
+		if previous, ok := extracted[normalized]; ok {
+			fmt.Fprintf(os.Stderr, "Skipping %s, which conflicts with %s\n", fileName, previous)
+			continue
+		}
+		extracted[normalized] = fileName
+
+		outputPath, err := extractPath(*outputDir, fileName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", fileName, err)
+			continue
+		}
 
 		// Create subdirectories if needed
 		if dir := filepath.Dir(outputPath); dir != "." {
@@ -97,11 +140,43 @@ func main() {
 	fmt.Printf("  Metadata saved to: %s\n", metadataPath)
 }
 
+// extractPath returns the path a file is extracted to. Backslashes are treated
+// as separators, and names leaving the output directory are rejected.
+func extractPath(outputDir, fileName string) (string, error) {
+	name := filepath.Clean(filepath.FromSlash(strings.ReplaceAll(fileName, "\\", "/")))
+	if !filepath.IsLocal(name) {
+		return "", errors.New("invalid file name")
+	}
+	return filepath.Join(outputDir, name), nil
+}
+
+// printSalvageReport prints what was recovered from a damaged package
+func printSalvageReport(report *osz2.SalvageReport) {
+	if report.Complete() {
+		fmt.Println("Package is intact")
+		return
+	}
+	fmt.Printf("Recovered %d files\n", len(report.Recovered))
+	for _, fileName := range report.Truncated {
+		fmt.Fprintf(os.Stderr, "Truncated: %s\n", fileName)
+	}
+	for _, fileName := range report.Missing {
+		fmt.Fprintf(os.Stderr, "Missing: %s\n", fileName)
+	}
+	for _, err := range report.FailedChecks {
+		fmt.Fprintf(os.Stderr, "Failed check: %v\n", err)
+	}
+	if report.Err != nil {
+		fmt.Fprintf(os.Stderr, "Stopped reading: %v\n", report.Err)
+	}
+}
+
 func printHelp() {
 	fmt.Println("osz2 Extractor - Extract .osz2 files and save metadata")
 	fmt.Println()
 	fmt.Println("Usage:")
-	fmt.Println("  osz2-cli -input <file.osz2> -output <directory> [-metadata <metadata.json>]")
+	fmt.Println("  osz2-cli -input <file.osz2> -output <directory> [-metadata <metadata.json>] [-salvage]")
+	fmt.Println("  osz2-cli inspect [-key <hex>] <file.osz2>")
 	fmt.Println()
 	fmt.Println("Flags:")
 	fmt.Println("  -input string")
@@ -110,10 +185,13 @@ func printHelp() {
 	fmt.Println("        Output directory for extracted files (required)")
 	fmt.Println("  -metadata string")
 	fmt.Println("        Output path for metadata JSON file (default: metadata.json in output directory)")
+	fmt.Println("  -salvage")
+	fmt.Println("        Extract the intact files of a damaged package")
 	fmt.Println("  -help")
 	fmt.Println("        Show this help message")
 	fmt.Println()
 	fmt.Println("Example:")
 	fmt.Println("  osz2-cli -input beatmap.osz2 -output ./extracted")
 	fmt.Println("  osz2-cli -input beatmap.osz2 -output ./extracted -metadata info.json")
+	fmt.Println("  osz2-cli inspect beatmap.osz2")
 }
@@ -22,6 +22,8 @@ type Metadata struct {
 	ArtistUnicode string            `json:"artist_unicode,omitempty"`
 	Difficulty    string            `json:"difficulty,omitempty"`
 	PreviewTime   string            `json:"preview_time,omitempty"`
+	FormatVersion byte              `json:"format_version"`
+	IV            string            `json:"iv"`
 	Attributes    map[string]string `json:"attributes"`
 	Files         []FileMetadata    `json:"files"`
 	Hashes        HashData          `json:"hashes"`
@@ -29,12 +31,13 @@ type Metadata struct {
 
 // FileMetadata represents file information in JSON format
 type FileMetadata struct {
-	FileName     string    `json:"file_name"`
-	Size         int32     `json:"size"`
-	Hash         string    `json:"hash"`
-	DateCreated  time.Time `json:"date_created"`
-	DateModified time.Time `json:"date_modified"`
-	BeatmapID    int32     `json:"beatmap_id,omitempty"`
+	FileName      string    `json:"file_name"`
+	Size          int32     `json:"size"`
+	ContentLength int32     `json:"content_length"`
+	Hash          string    `json:"hash"`
+	DateCreated   time.Time `json:"date_created"`
+	DateModified  time.Time `json:"date_modified"`
+	BeatmapID     int32     `json:"beatmap_id,omitempty"`
 }
 
 // HashData represents hash information
@@ -46,8 +49,10 @@ type HashData struct {
 
 func buildMetadata(pkg *osz2.Package) *Metadata {
 	metadata := &Metadata{
-		Attributes: make(map[string]string),
-		Files:      make([]FileMetadata, 0),
+		FormatVersion: pkg.Version,
+		IV:            fmt.Sprintf("%x", pkg.IV),
+		Attributes:    make(map[string]string),
+		Files:         make([]FileMetadata, 0, len(pkg.Entries)),
 		Hashes: HashData{
 			MetaDataHash: fmt.Sprintf("%x", pkg.MetaDataHash),
 			FileInfoHash: fmt.Sprintf("%x", pkg.FileInfoHash),
@@ -92,17 +97,19 @@ func buildMetadata(pkg *osz2.Package) *Metadata {
 	}
 
 	// Add file information
-	for fileName, fileInfo := range pkg.FileInfos {
+	for _, fileInfo := range pkg.Entries {
+		fileName := fileInfo.FileName
 		fileMeta := FileMetadata{
-			FileName:     fileName,
-			Size:         fileInfo.Size,
-			DateCreated:  fileInfo.DateCreated,
-			DateModified: fileInfo.DateModified,
-			Hash:         fmt.Sprintf("%x", fileInfo.Hash),
+			FileName:      fileName,
+			Size:          fileInfo.Size,
+			ContentLength: fileInfo.ContentLength,
+			DateCreated:   fileInfo.DateCreated,
+			DateModified:  fileInfo.DateModified,
+			Hash:          fmt.Sprintf("%x", fileInfo.Hash),
 		}
 
 		// Add beatmap ID if available
-		if beatmapID, ok := pkg.FileNames[fileName]; ok {
+		if beatmapID, ok := pkg.BeatmapID(fileName); ok {
 			fileMeta.BeatmapID = beatmapID
 		}
 
@@ -5,4 +5,9 @@ const (
 	TEADelta uint32 = 0x9e3779b9
 	// TEARounds number of rounds for TEA algorithm
 	TEARounds uint32 = 32
+
+	// FormatVersion is the only known version of the osz2 format
+	FormatVersion byte = 0
+	// IVSize is the size of the initialization vector in the header
+	IVSize = 16
 )
@@ -2,6 +2,8 @@ package osz2
 
 import (
 	"crypto/md5"
+	"encoding/hex"
+	"hash"
 )
 
 // ComputeHash computes MD5 hash of a string
@@ -12,11 +14,7 @@ func ComputeHash(str string) string {
 // ComputeHashBytes computes MD5 hash of byte array and returns hex string
 func ComputeHashBytes(data []byte) string {
 	hash := md5.Sum(data)
-	result := ""
-	for _, b := range hash[:] {
-		result += byteToHex(b)
-	}
-	return result
+	return hex.EncodeToString(hash[:])
 }
 
 // ComputeHashBytesRaw computes MD5 hash and returns raw bytes
@@ -25,8 +23,50 @@ func ComputeHashBytesRaw(data []byte) []byte {
 	return hash[:]
 }
 
-// byteToHex is a simple implementation for formatting hex
-func byteToHex(b byte) string {
-	hex := "0123456789abcdef"
-	return string([]byte{hex[b>>4], hex[b&0xf]})
+// computeOszHash computes MD5 hash of .osz parts
+func computeOszHash(buffer []byte, pos int, swap byte) []byte {
+	hasher := newOszHasher(pos, swap)
+	hasher.Write(buffer)
+	return hasher.Sum()
+}
+
+// oszHasher computes the hash of .osz parts from a stream.
+// The byte at pos is xor'ed with swap before hashing; if the
+// stream is shorter than pos, it is hashed unmodified.
+type oszHasher struct {
+	md5     hash.Hash
+	written int
+	pos     int
+	swap    byte
+}
+
+// newOszHasher creates a new oszHasher
+func newOszHasher(pos int, swap byte) *oszHasher {
+	return &oszHasher{md5: md5.New(), pos: pos, swap: swap}
+}
+
+// Write adds data to the hash without modifying it
+func (h *oszHasher) Write(p []byte) (int, error) {
+	if i := h.pos - h.written; i >= 0 && i < len(p) {
+		h.md5.Write(p[:i])
+		h.md5.Write([]byte{p[i] ^ h.swap})
+		h.md5.Write(p[i+1:])
+	} else {
+		h.md5.Write(p)
+	}
+	h.written += len(p)
+	return len(p), nil
+}
+
+// Sum returns the final hash
+func (h *oszHasher) Sum() []byte {
+	hash := h.md5.Sum(nil)
+
+	// Swap bytes as in C# implementation
+	for i := 0; i < 8; i++ {
+		hash[i], hash[i+8] = hash[i+8], hash[i]
+	}
+
+	hash[5] ^= 0x2d
+	return hash
 }
@@ -0,0 +1,428 @@
+package osz2
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+)
+
+// benchmarkKey is the key of the nekodex test package
+var benchmarkKey = bytesToUint32Array(DeriveKey("peppy", "-1"))
+
+// loadBenchmarkData reads a test package to use as cipher input
+func loadBenchmarkData(b *testing.B) []byte {
+	data, err := os.ReadFile("tests/nekodex - welcome to christmas.osz2")
+	if err != nil {
+		b.Skip("Test file does not exist")
+	}
+	return data
+}
+
+// karooKey is the key of the Karoo13 test package
+const karooKey = "862e2767f9118dc2689c92a8427345b6"
+
+// cipherVector is a known-answer test vector. The ciphertexts are taken
+// from the test packages, which were produced by osu!'s C# implementation.
+// The paths that do not occur in the test packages are covered by vectors
+// produced by this implementation, which only guard against regressions.
+type cipherVector struct {
+	name       string
+	cipher     func(key []uint32) Cipher
+	plaintext  string
+	ciphertext string
+}
+
+var cipherVectors = []cipherVector{
+	{
+		// The 64-byte magic block following the filename mapping
+		name:       "XTEA magic block",
+		cipher:     func(key []uint32) Cipher { return NewXTEA(key) },
+		plaintext:  "55aa74102b56b39e259efeb7be06fcf2b63c6f477e38694380892500ccb6fe12a9b24a2c96d5ea264231af0a0dae00edfe96a69499a790e468bfc6975b1b5e7f",
+		ciphertext: "e2498bbcf474be18a00d98cf80b2d27ba6f568ad8d4009c45a8a025dcc12652bc5bf17243bf7c2dd9a70a0ad2d3706323dd518072abcde4dff7884e0eb7797e2",
+	},
+	{
+		// First 64-byte block of hit0-0.png
+		name:       "XXTEA full block",
+		cipher:     func(key []uint32) Cipher { return NewXXTEA(key) },
+		plaintext:  "89504e470d0a1a0a0000000d49484452000000010000000108060000001f15c4890000000a49444154789c63000100000500010d0a2db40000000049454e44ae",
+		ciphertext: "4e9c40cd426c0f8394cd6284b437a1a1e6488e623153f13df0d896abb9e67613c9603eb2278f7e4ae7e96a04e1d50f400878f5ab85261ef13e74695e05c13aa6",
+	},
+	{
+		// Last 51 bytes of a .osu file: 12 words followed by 3 bytes
+		name:       "XXTEA words and leftover",
+		cipher:     func(key []uint32) Cipher { return NewXXTEA(key) },
+		plaintext:  "38382c31343136312c312c302c303a303a383a303a0d0a3335322c3238382c31343136312c312c302c303a303a393a303a0d0a",
+		ciphertext: "ac0a7013c03e649df5d45d0c4d09cf781f43bbad6eff05f845e07e7db9c53e12b3f7479123973c169c6d761b3eabf50edd2544",
+	},
+	{
+		// Last 27 bytes of o.png: 6 words followed by 3 bytes
+		name:       "XXTEA short tail",
+		cipher:     func(key []uint32) Cipher { return NewXXTEA(key) },
+		plaintext:  "5656cb1a31e25fed5337e3c0b16bf60000000049454e44ae426082",
+		ciphertext: "981c4cb8415c2a66c17b23739f29125e8b255563d6f4bf4bda50bc",
+	},
+	{
+		// Encrypted length prefix of hit0-0.png (67 bytes)
+		name:       "XXTEA single word",
+		cipher:     func(key []uint32) Cipher { return NewXXTEA(key) },
+		plaintext:  "43000000",
+		ciphertext: "e1d7d304",
+	},
+	{
+		// Last 3 bytes of hit0-0.png
+		name:       "SimpleCryptor",
+		cipher:     func(key []uint32) Cipher { return NewSimpleCryptor(key) },
+		plaintext:  "426082",
+		ciphertext: "da50bc",
+	},
+	{
+		// Last 9 bytes of bg.jpg: the smallest word block (2 words) followed by 1 byte
+		name:       "XXTEA two words",
+		cipher:     func(key []uint32) Cipher { return NewXXTEA(key) },
+		plaintext:  "a28a0028a28a00ffd9",
+		ciphertext: "c20436022357ac35f2",
+	},
+	{
+		// Last 40 bytes of audio.mp3: 10 words without leftover
+		name:       "XXTEA words only",
+		cipher:     func(key []uint32) Cipher { return NewXXTEA(key) },
+		plaintext:  "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		ciphertext: "c7c785a3371eec9061f26ded449e369ce2005171929b88c88fdd6af434eaa0d32a99cae6c661ac9a",
+	},
+	{
+		// Last 58 bytes of board.png: 14 words followed by 2 bytes
+		name:       "XXTEA words and two bytes",
+		cipher:     func(key []uint32) Cipher { return NewXXTEA(key) },
+		plaintext:  "00000000000000000000000000000000000000000000000000000000000000005f836fbef90b03a133814f1ffefc0000000049454e44ae426082",
+		ciphertext: "a2083d4cb4c43b84121b2713fbb7bb713b264e61507c82829c4c98ba4a9a86b65ef1de48ca225540cc6210f26325e6711482353fdc72753e6bd3",
+	},
+	{
+		// Last 63 bytes of a .osu file: the largest word block (15 words) followed by 3 bytes
+		name:       "XXTEA longest tail",
+		cipher:     func(key []uint32) Cipher { return NewXXTEA(key) },
+		plaintext:  "3a373a303a0d0a3235362c3238382c31343136312c312c302c303a303a383a303a0d0a3335322c3238382c31343136312c312c302c303a303a393a303a0d0a",
+		ciphertext: "28afb6130fa3c3519a71e53f6237d2090852463b7e4222690f845fb914bb9a9b8a2c2349b7efaddfb727a8360a0250fc7e701736ee4d1968eb9fe2dadd2544",
+	},
+	{
+		// Regression vector: a single word and 2 bytes, all encrypted by SimpleCryptor
+		name:       "XXTEA single word and leftover",
+		cipher:     func(key []uint32) Cipher { return NewXXTEA(key) },
+		plaintext:  "6f7375210d0a",
+		ciphertext: "a7a204e18355",
+	},
+	{
+		// Regression vector: one 8-byte word followed by 7 bytes for SimpleCryptor
+		name:       "XTEA leftover",
+		cipher:     func(key []uint32) Cipher { return NewXTEA(key) },
+		plaintext:  "6f73752066696c6520666f726d6174",
+		ciphertext: "036814b475ab9cecfefd29999007d5",
+	},
+}
+
+// decodeHex decodes a hex string or fails the test
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("Invalid hex in test vector: %v", err)
+	}
+	return data
+}
+
+// TestDeriveKey checks the key derivation against the key of a test package
+func TestDeriveKey(t *testing.T) {
+	key := DeriveKey("Karoo13", "864877")
+	if hex.EncodeToString(key) != karooKey {
+		t.Errorf("Got key %x, expected %s", key, karooKey)
+	}
+}
+
+// TestCipherVectors checks all ciphers against known-answer vectors
+func TestCipherVectors(t *testing.T) {
+	key := bytesToUint32Array(decodeHex(t, karooKey))
+
+	for _, vector := range cipherVectors {
+		t.Run(vector.name, func(t *testing.T) {
+			plaintext := decodeHex(t, vector.plaintext)
+			ciphertext := decodeHex(t, vector.ciphertext)
+			c := vector.cipher(key)
+
+			buffer := append([]byte{}, ciphertext...)
+			c.Decrypt(buffer, 0, len(buffer))
+			if !bytes.Equal(buffer, plaintext) {
+				t.Errorf("Decrypt: got %x, expected %x", buffer, plaintext)
+			}
+
+			buffer = append([]byte{}, plaintext...)
+			c.Encrypt(buffer, 0, len(buffer))
+			if !bytes.Equal(buffer, ciphertext) {
+				t.Errorf("Encrypt: got %x, expected %x", buffer, ciphertext)
+			}
+		})
+	}
+}
+
+// TestCipherRoundTrip encrypts and decrypts every length from 0 to 512 bytes at
+// random offsets, which covers every split into full blocks, word blocks and
+// SimpleCryptor tails. The bytes around the range must not change, and the
+// output must not depend on the offset.
+func TestCipherRoundTrip(t *testing.T) {
+	key := bytesToUint32Array(decodeHex(t, karooKey))
+	ciphers := map[string]Cipher{
+		"XTEA":          NewXTEA(key),
+		"XXTEA":         NewXXTEA(key),
+		"SimpleCryptor": NewSimpleCryptor(key),
+	}
+	random := rand.New(rand.NewSource(1))
+
+	for name, c := range ciphers {
+		t.Run(name, func(t *testing.T) {
+			for length := 0; length <= 512; length++ {
+				offset := random.Intn(64)
+				original := make([]byte, offset+length+16)
+				random.Read(original)
+				plaintext := original[offset : offset+length]
+
+				buffer := bytes.Clone(original)
+				c.Encrypt(buffer, offset, length)
+				if !bytes.Equal(buffer[:offset], original[:offset]) || !bytes.Equal(buffer[offset+length:], original[offset+length:]) {
+					t.Fatalf("Length %d at offset %d: encrypt changed bytes outside the range", length, offset)
+				}
+
+				aligned := bytes.Clone(plaintext)
+				c.Encrypt(aligned, 0, length)
+				if !bytes.Equal(aligned, buffer[offset:offset+length]) {
+					t.Fatalf("Length %d: output at offset %d differs from offset 0", length, offset)
+				}
+				if length >= 8 && bytes.Equal(aligned, plaintext) {
+					t.Fatalf("Length %d: encrypt did not change the data", length)
+				}
+
+				c.Decrypt(buffer, offset, length)
+				if !bytes.Equal(buffer, original) {
+					t.Fatalf("Length %d at offset %d did not round-trip", length, offset)
+				}
+			}
+		})
+	}
+}
+
+// TestRotate checks the byte rotations for every rotation count SimpleCryptor uses
+func TestRotate(t *testing.T) {
+	for n := byte(0); n < 8; n++ {
+		for v := 0; v < 256; v++ {
+			val := byte(v)
+			if got := rotateRight(rotateLeft(val, n), n); got != val {
+				t.Fatalf("rotateRight(rotateLeft(%#x, %d)) = %#x", val, n, got)
+			}
+			if got, expected := rotateLeft(val, n), byte(uint16(val)<<n|uint16(val)>>(8-n)); got != expected {
+				t.Fatalf("rotateLeft(%#x, %d) = %#x, expected %#x", val, n, got, expected)
+			}
+		}
+	}
+}
+
+// TestBlockAdapter checks the cipher.Block adapter against the known-answer vectors
+func TestBlockAdapter(t *testing.T) {
+	key := bytesToUint32Array(decodeHex(t, karooKey))
+
+	// XTEA encrypts every 8-byte word independently, so ECB over
+	// the adapter has to reproduce the whole magic block
+	magic := cipherVectors[0]
+	plaintext := decodeHex(t, magic.plaintext)
+	ciphertext := decodeHex(t, magic.ciphertext)
+
+	block := NewBlock(NewXTEA(key), XTEABlockSize)
+	output := make([]byte, len(plaintext))
+	for i := 0; i < len(plaintext); i += block.BlockSize() {
+		block.Encrypt(output[i:], plaintext[i:])
+	}
+	if !bytes.Equal(output, ciphertext) {
+		t.Errorf("XTEA block encrypt: got %x, expected %x", output, ciphertext)
+	}
+
+	// XXTEA blocks are the 64-byte blocks of a file body
+	vector := cipherVectors[1]
+	block = NewBlock(NewXXTEA(key), XXTEABlockSize)
+	output = make([]byte, XXTEABlockSize)
+	block.Decrypt(output, decodeHex(t, vector.ciphertext))
+	if !bytes.Equal(output, decodeHex(t, vector.plaintext)) {
+		t.Errorf("XXTEA block decrypt: got %x, expected %s", output, vector.plaintext)
+	}
+}
+
+// TestStreamAdapter checks that cipher.StreamReader over a decrypt stream
+// reads the same data as XXTEAReader
+func TestStreamAdapter(t *testing.T) {
+	key := bytesToUint32Array(decodeHex(t, karooKey))
+	vector := cipherVectors[2]
+	ciphertext := decodeHex(t, vector.ciphertext)
+
+	expected, err := io.ReadAll(NewXXTEAReader(bytes.NewReader(ciphertext), key))
+	if err != nil {
+		t.Fatalf("Failed to read from XXTEAReader: %v", err)
+	}
+
+	reader := cipher.StreamReader{
+		S: NewDecryptStream(NewXXTEA(key)),
+		R: bytes.NewReader(ciphertext),
+	}
+	output, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read from stream: %v", err)
+	}
+
+	if !bytes.Equal(output, expected) {
+		t.Errorf("Stream decrypt: got %x, expected %x", output, expected)
+	}
+}
+
+// TestConcurrentDecrypt decrypts every file of a package from many goroutines
+// sharing a single XXTEA instance; run with -race to detect shared state
+func TestConcurrentDecrypt(t *testing.T) {
+	data, pkg := readTestPackage(t, "tests/Karoo13 - Tic Tac Toe.osz2", false)
+
+	xxtea := NewXXTEA(bytesToUint32Array(pkg.key))
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		for fileName, fileInfo := range pkg.FileInfos {
+			wg.Add(1)
+			go func(fileName string, fileInfo *FileInfo) {
+				defer wg.Done()
+
+				// Every goroutine needs its own reader, only the cipher is shared
+				offset := int(pkg.fileOffset) + int(fileInfo.Offset)
+				reader, err := NewOsz2ReaderWithCipher(bytes.NewReader(data), offset, xxtea)
+				if err != nil {
+					t.Errorf("Failed to create reader for %s: %v", fileName, err)
+					return
+				}
+
+				content := make([]byte, fileInfo.Size-4)
+				if _, err := reader.Read(content); err != nil {
+					t.Errorf("Failed to read %s: %v", fileName, err)
+					return
+				}
+
+				if !bytes.Equal(content, pkg.Files[fileName]) {
+					t.Errorf("File %s: concurrent decryption differs from sequential", fileName)
+				}
+			}(fileName, fileInfo)
+		}
+	}
+	wg.Wait()
+}
+
+// TestConcurrentRoundTrip encrypts and decrypts buffers of every length
+// class from many goroutines sharing the same cipher instances
+func TestConcurrentRoundTrip(t *testing.T) {
+	xxtea := NewXXTEA(benchmarkKey)
+	cryptor := NewSimpleCryptor(benchmarkKey)
+
+	ciphers := map[string]struct {
+		encrypt, decrypt func([]byte)
+	}{
+		"XXTEA": {
+			func(b []byte) { xxtea.Encrypt(b, 0, len(b)) },
+			func(b []byte) { xxtea.Decrypt(b, 0, len(b)) },
+		},
+		"SimpleCryptor": {cryptor.EncryptBytes, cryptor.DecryptBytes},
+	}
+
+	var wg sync.WaitGroup
+	for name, c := range ciphers {
+		for length := 0; length < 200; length++ {
+			wg.Add(1)
+			go func(name string, length int, encrypt, decrypt func([]byte)) {
+				defer wg.Done()
+
+				plain := []byte(fmt.Sprintf("%0*d", length, length))
+				buffer := append([]byte{}, plain...)
+				encrypt(buffer)
+				decrypt(buffer)
+
+				if !bytes.Equal(buffer, plain) {
+					t.Errorf("%s: length %d did not round-trip", name, length)
+				}
+			}(name, length, c.encrypt, c.decrypt)
+		}
+	}
+	wg.Wait()
+}
+
+// BenchmarkXXTEADecrypt benchmarks XXTEA decryption of a whole package
+func BenchmarkXXTEADecrypt(b *testing.B) {
+	data := loadBenchmarkData(b)
+	xxtea := NewXXTEA(benchmarkKey)
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		xxtea.Decrypt(data, 0, len(data))
+	}
+}
+
+// BenchmarkXXTEAEncrypt benchmarks XXTEA encryption of a whole package
+func BenchmarkXXTEAEncrypt(b *testing.B) {
+	data := loadBenchmarkData(b)
+	xxtea := NewXXTEA(benchmarkKey)
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		xxtea.Encrypt(data, 0, len(data))
+	}
+}
+
+// BenchmarkXXTEADecryptSmall benchmarks XXTEA decryption of short buffers,
+// which take the word and SimpleCryptor paths used by the file info table
+func BenchmarkXXTEADecryptSmall(b *testing.B) {
+	data := loadBenchmarkData(b)
+	xxtea := NewXXTEA(benchmarkKey)
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for offset := 0; offset+15 <= len(data); offset += 15 {
+			xxtea.Decrypt(data, offset, 15)
+		}
+	}
+}
+
+// BenchmarkXTEADecrypt benchmarks XTEA decryption of a whole package
+func BenchmarkXTEADecrypt(b *testing.B) {
+	data := loadBenchmarkData(b)
+	xtea := NewXTEA(benchmarkKey)
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		xtea.Decrypt(data, 0, len(data))
+	}
+}
+
+// BenchmarkSimpleCryptorDecrypt benchmarks SimpleCryptor on short buffers
+func BenchmarkSimpleCryptorDecrypt(b *testing.B) {
+	data := loadBenchmarkData(b)
+	cryptor := NewSimpleCryptor(benchmarkKey)
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for offset := 0; offset+7 <= len(data); offset += 7 {
+			cryptor.DecryptBytes(data[offset : offset+7])
+		}
+	}
+}
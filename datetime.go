@@ -0,0 +1,29 @@
+package osz2
+
+import (
+	"time"
+
+	"github.com/Lekuruu/osz2-go/dotnet"
+)
+
+// DateTimeKind is the kind of a .NET DateTime value,
+// which tells how its ticks relate to UTC
+type DateTimeKind = dotnet.DateTimeKind
+
+const (
+	KindUnspecified = dotnet.KindUnspecified
+	KindUtc         = dotnet.KindUtc
+	KindLocal       = dotnet.KindLocal
+)
+
+// FromDotNetBinary converts a .NET DateTime.ToBinary() value to a Go time.Time,
+// see dotnet.FromBinary
+func FromDotNetBinary(value int64) (time.Time, DateTimeKind) {
+	return dotnet.FromBinary(value)
+}
+
+// ToDotNetBinary converts a Go time.Time to a .NET DateTime.ToBinary() value
+// of the given kind, see dotnet.ToBinary
+func ToDotNetBinary(t time.Time, kind DateTimeKind) int64 {
+	return dotnet.ToBinary(t, kind)
+}
@@ -0,0 +1,103 @@
+package dotnet
+
+import (
+	"time"
+)
+
+// DateTimeKind is the kind of a .NET DateTime value,
+// which tells how its ticks relate to UTC
+type DateTimeKind uint8
+
+const (
+	// KindUnspecified is a wall clock time without a time zone
+	KindUnspecified DateTimeKind = iota
+	// KindUtc is a time in UTC
+	KindUtc
+	// KindLocal is a time in the local time zone of the machine that wrote it
+	KindLocal
+)
+
+const (
+	// unixEpochTicks is the number of ticks (100ns) between January 1, 0001 and the Unix epoch
+	unixEpochTicks = 621355968000000000
+	// ticksPerSecond is the number of ticks in a second
+	ticksPerSecond = 10000000
+	// ticksPerDay is the number of ticks in a day
+	ticksPerDay = 864000000000
+
+	// Layout of DateTime.ToBinary() values: the kind is stored in the upper 2 bits
+	ticksMask     = 0x3FFFFFFFFFFFFFFF
+	ticksCeil     = 0x4000000000000000
+	kindShift     = 62
+	kindUtcBits   = 0x4000000000000000
+	kindLocalBits = -0x8000000000000000
+)
+
+// String returns the name of the kind as in .NET
+func (k DateTimeKind) String() string {
+	switch k {
+	case KindUnspecified:
+		return "Unspecified"
+	case KindUtc:
+		return "Utc"
+	case KindLocal:
+		return "Local"
+	default:
+		return "Unknown"
+	}
+}
+
+// FromBinary converts a .NET DateTime.ToBinary() value to a Go time.Time.
+// Utc values are returned in UTC and Local values, which .NET stores as UTC
+// ticks, in time.Local. Unspecified values have no time zone; their wall clock
+// is returned as if it was UTC.
+func FromBinary(value int64) (time.Time, DateTimeKind) {
+	ticks := value & ticksMask
+	kind := DateTimeKind(uint64(value) >> kindShift)
+
+	switch kind {
+	case KindUtc:
+		return ticksToTime(ticks), KindUtc
+	case KindUnspecified:
+		return ticksToTime(ticks), KindUnspecified
+	default:
+		// Local times that were close to DateTime.MinValue in UTC
+		// wrap around to the top of the tick range
+		if ticks > ticksCeil-ticksPerDay {
+			ticks -= ticksCeil
+		}
+		return ticksToTime(ticks).In(time.Local), KindLocal
+	}
+}
+
+// ToBinary converts a Go time.Time to a .NET DateTime.ToBinary() value of
+// the given kind. Utc and Local values store the instant of t, Unspecified
+// values store the wall clock of t in its own location.
+func ToBinary(t time.Time, kind DateTimeKind) int64 {
+	switch kind {
+	case KindUtc:
+		return timeToTicks(t) | kindUtcBits
+	case KindLocal:
+		ticks := timeToTicks(t)
+		if ticks < 0 {
+			ticks += ticksCeil
+		}
+		return ticks | kindLocalBits
+	default:
+		_, offset := t.Zone()
+		return timeToTicks(t) + int64(offset)*ticksPerSecond
+	}
+}
+
+// ticksToTime converts .NET ticks since January 1, 0001 UTC to a time.Time
+func ticksToTime(ticks int64) time.Time {
+	ticks -= unixEpochTicks
+	seconds := ticks / ticksPerSecond
+	remainder := ticks % ticksPerSecond
+	return time.Unix(seconds, remainder*100).UTC()
+}
+
+// timeToTicks converts a time.Time to .NET ticks since January 1, 0001 UTC
+func timeToTicks(t time.Time) int64 {
+	return t.Unix()*ticksPerSecond + int64(t.Nanosecond()/100) + unixEpochTicks
+}
@@ -0,0 +1,233 @@
+package dotnet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestDateTimeBinary tests converting timestamps of every kind to and from DateTime.ToBinary values
+func TestDateTimeBinary(t *testing.T) {
+	// Values produced by DateTime.ToBinary() for 2013-05-04 12:34:56.789 UTC
+	instant := time.Date(2013, 5, 4, 12, 34, 56, 789000000, time.UTC)
+	const ticks = 635032676967890000
+
+	tests := []struct {
+		kind  DateTimeKind
+		value int64
+	}{
+		{KindUnspecified, ticks},
+		{KindUtc, ticks | 0x4000000000000000},
+	}
+
+	for _, test := range tests {
+		if value := ToBinary(instant, test.kind); value != test.value {
+			t.Errorf("ToBinary(%s) = %#x, expected %#x", test.kind, value, test.value)
+		}
+		decoded, kind := FromBinary(test.value)
+		if kind != test.kind {
+			t.Errorf("FromBinary(%#x) kind = %s, expected %s", test.value, kind, test.kind)
+		}
+		if !decoded.Equal(instant) {
+			t.Errorf("FromBinary(%#x) = %v, expected %v", test.value, decoded, instant)
+		}
+	}
+}
+
+// TestDateTimeBinaryLocal tests local and unspecified timestamps in a time zone other than UTC
+func TestDateTimeBinaryLocal(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+	defer func() { time.Local = local }()
+
+	instant := time.Date(2013, 5, 4, 12, 34, 56, 0, time.UTC)
+	const ticks = 635032676960000000
+
+	// Local values store the UTC ticks with the high bit set
+	value := ToBinary(instant, KindLocal)
+	if expected := int64(ticks) | -1<<63; value != expected {
+		t.Errorf("ToBinary(Local) = %#x, expected %#x", value, expected)
+	}
+
+	decoded, kind := FromBinary(value)
+	if kind != KindLocal {
+		t.Errorf("Expected kind Local, got %s", kind)
+	}
+	if !decoded.Equal(instant) || decoded.Location() != time.Local {
+		t.Errorf("FromBinary(Local) = %v, expected %v in local time", decoded, instant)
+	}
+
+	// Unspecified values keep the wall clock of the time's own location
+	wall := instant.In(time.Local)
+	if value := ToBinary(wall, KindUnspecified); value != ticks+2*60*60*10000000 {
+		t.Errorf("ToBinary(Unspecified) = %d, expected wall clock ticks", value)
+	}
+
+	// Local times just after DateTime.MinValue have negative UTC ticks, which wrap around
+	minValue := time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC).Add(-time.Hour)
+	decoded, _ = FromBinary(ToBinary(minValue, KindLocal))
+	if !decoded.Equal(minValue) {
+		t.Errorf("Negative local ticks decoded as %v, expected %v", decoded, minValue)
+	}
+}
+
+// Test7BitEncodedInt tests encoding and decoding 7-bit encoded integers
+func Test7BitEncodedInt(t *testing.T) {
+	tests := []struct {
+		value   int32
+		encoded []byte
+	}{
+		{0, []byte{0x00}},
+		{0x7F, []byte{0x7F}},
+		{0x80, []byte{0x80, 0x01}},
+		{300, []byte{0xAC, 0x02}},
+		{0x7FFFFFFF, []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x07}},
+		{-1, []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x0F}},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := NewWriter(&buf).Write7BitEncodedInt(test.value); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), test.encoded) {
+			t.Errorf("Write7BitEncodedInt(%d) = %x, expected %x", test.value, buf.Bytes(), test.encoded)
+		}
+
+		value, err := NewReader(bytes.NewReader(test.encoded)).Read7BitEncodedInt()
+		if err != nil {
+			t.Errorf("Read7BitEncodedInt(%x) failed: %v", test.encoded, err)
+		} else if value != test.value {
+			t.Errorf("Read7BitEncodedInt(%x) = %d, expected %d", test.encoded, value, test.value)
+		}
+	}
+}
+
+// TestReaderErrors tests that malformed and truncated values are rejected
+func TestReaderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"fifth byte overflow", []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x10}, ErrBad7BitInt},
+		{"six bytes", []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x00}, ErrBad7BitInt},
+		{"negative length", []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x0F}, ErrNegativeLength},
+		{"truncated length", []byte{0x80}, io.EOF},
+		{"truncated string", []byte{0x05, 'a', 'b'}, io.ErrUnexpectedEOF},
+		{"missing string", []byte{0x05}, io.ErrUnexpectedEOF},
+		{"too long", []byte{0x11}, ErrStringTooLong},
+	}
+
+	for _, test := range tests {
+		reader := NewReader(bytes.NewReader(test.data))
+		reader.MaxStringLength = 16
+		if _, err := reader.ReadString(); !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+
+	if _, err := NewReader(bytes.NewReader([]byte{1, 2, 3})).ReadInt32(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected %v for a truncated int32, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+// allocated returns the number of bytes allocated by f
+func allocated(f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+// TestReaderLargeLength checks that length prefixes beyond the end of the
+// input are rejected before the value is allocated
+func TestReaderLargeLength(t *testing.T) {
+	data := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x07, 'a', 'b', 'c'}
+
+	tests := map[string]func() io.Reader{
+		"bytes":   func() io.Reader { return bytes.NewReader(data) },
+		"limited": func() io.Reader { return &io.LimitedReader{R: bytes.NewReader(data), N: int64(len(data))} },
+		"seeker":  func() io.Reader { return io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))) },
+	}
+
+	for name, newReader := range tests {
+		var err error
+		n := allocated(func() {
+			_, err = NewReader(newReader()).ReadString()
+		})
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%s: expected %v, got %v", name, io.ErrUnexpectedEOF, err)
+		}
+		if n > 1<<20 {
+			t.Errorf("%s: allocated %d bytes", name, n)
+		}
+	}
+}
+
+// chunkRecorder records the size of every write
+type chunkRecorder struct {
+	bytes.Buffer
+	chunks []int
+}
+
+func (c *chunkRecorder) Write(p []byte) (int, error) {
+	c.chunks = append(c.chunks, len(p))
+	return c.Buffer.Write(p)
+}
+
+// TestRoundTrip tests that every value written by Writer is read back by Reader
+func TestRoundTrip(t *testing.T) {
+	timestamp := time.Date(2013, 5, 4, 12, 34, 56, 0, time.UTC)
+
+	var buf chunkRecorder
+	writer := NewWriter(&buf)
+	writer.WriteByte(0x42)
+	writer.WriteInt16(-2)
+	writer.WriteInt32(0x12345678)
+	writer.WriteInt64(-3)
+	writer.WriteString("Tic Tac Toe")
+	writer.WriteString("")
+	writer.WriteBytes([]byte{1, 2, 3})
+	writer.WriteDateTime(timestamp, KindUtc)
+
+	// BinaryWriter writes every value and every byte of a 7-bit integer separately
+	expected := []int{1, 2, 4, 8, 1, 11, 1, 3, 8}
+	if !slices.Equal(buf.chunks, expected) {
+		t.Errorf("Writes were chunked as %v, expected %v", buf.chunks, expected)
+	}
+
+	reader := NewReader(&buf.Buffer)
+	if b, err := reader.ReadByte(); err != nil || b != 0x42 {
+		t.Errorf("ReadByte = %#x, %v", b, err)
+	}
+	if v, err := reader.ReadInt16(); err != nil || v != -2 {
+		t.Errorf("ReadInt16 = %d, %v", v, err)
+	}
+	if v, err := reader.ReadInt32(); err != nil || v != 0x12345678 {
+		t.Errorf("ReadInt32 = %#x, %v", v, err)
+	}
+	if v, err := reader.ReadInt64(); err != nil || v != -3 {
+		t.Errorf("ReadInt64 = %d, %v", v, err)
+	}
+	if s, err := reader.ReadString(); err != nil || s != "Tic Tac Toe" {
+		t.Errorf("ReadString = %q, %v", s, err)
+	}
+	if s, err := reader.ReadString(); err != nil || s != "" {
+		t.Errorf("ReadString = %q, %v", s, err)
+	}
+	if data, err := reader.ReadBytes(3); err != nil || !bytes.Equal(data, []byte{1, 2, 3}) {
+		t.Errorf("ReadBytes = %v, %v", data, err)
+	}
+	if v, kind, err := reader.ReadDateTime(); err != nil || !v.Equal(timestamp) || kind != KindUtc {
+		t.Errorf("ReadDateTime = %v (%s), %v", v, kind, err)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected EOF after the last value, got %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+// Package dotnet reads and writes the primitives of .NET's BinaryReader and
+// BinaryWriter: little-endian numbers, 7-bit encoded integers, length-prefixed
+// strings and DateTime values.
+package dotnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	// ErrBad7BitInt is returned for 7-bit encoded integers that do not fit in 32 bits
+	ErrBad7BitInt = errors.New("7-bit encoded integer is too large")
+
+	// ErrNegativeLength is returned for strings with a negative length prefix
+	ErrNegativeLength = errors.New("negative string length")
+
+	// ErrStringTooLong is returned for strings longer than the reader's limit
+	ErrStringTooLong = errors.New("string exceeds the maximum length")
+)
+
+// largeRead is the size above which ReadBytes checks that the input has
+// enough bytes left before allocating
+const largeRead = 64 << 10
+
+// Reader reads .NET BinaryReader primitives from an io.Reader. Every value
+// is read with a single call to the underlying reader, or one call per byte
+// for 7-bit encoded integers, which matters for chunked streams like XXTeaStream.
+//
+// Before allocating a large value, the Reader checks that the input has enough
+// bytes left, if the underlying reader reports them: readers with a Len method
+// like bytes.Reader, *io.LimitedReader and io.Seeker implementations.
+// For other readers, MaxStringLength is the only limit.
+type Reader struct {
+	r   io.Reader
+	buf [8]byte
+
+	// MaxStringLength limits the length of strings read with ReadString,
+	// to avoid large allocations for corrupted length prefixes. Zero means no limit.
+	MaxStringLength int
+}
+
+// NewReader creates a new Reader reading from r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// read reads exactly n bytes into the internal buffer
+func (r *Reader) read(n int) ([]byte, error) {
+	if _, err := io.ReadFull(r.r, r.buf[:n]); err != nil {
+		return nil, err
+	}
+	return r.buf[:n], nil
+}
+
+// ReadByte reads a single byte
+func (r *Reader) ReadByte() (byte, error) {
+	b, err := r.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// remaining returns the number of bytes left in the underlying reader, if it reports them
+func (r *Reader) remaining() (int64, bool) {
+	switch reader := r.r.(type) {
+	case interface{ Len() int }:
+		return int64(reader.Len()), true
+	case *io.LimitedReader:
+		return reader.N, true
+	case io.Seeker:
+		current, err := reader.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		end, err := reader.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, false
+		}
+		if _, err := reader.Seek(current, io.SeekStart); err != nil {
+			return 0, false
+		}
+		return end - current, true
+	}
+	return 0, false
+}
+
+// ReadBytes reads exactly n bytes. It returns io.ErrUnexpectedEOF without
+// allocating if the underlying reader reports fewer than n bytes left.
+func (r *Reader) ReadBytes(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, ErrNegativeLength
+	}
+	if n > largeRead {
+		if remaining, ok := r.remaining(); ok && int64(n) > remaining {
+			return nil, io.ErrUnexpectedEOF
+		}
+	}
+	data := make([]byte, n)
+	if n == 0 {
+		return data, nil
+	}
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ReadInt16 reads a little-endian int16
+func (r *Reader) ReadInt16() (int16, error) {
+	b, err := r.read(2)
+	if err != nil {
+		return 0, err
+	}
+	return int16(binary.LittleEndian.Uint16(b)), nil
+}
+
+// ReadInt32 reads a little-endian int32
+func (r *Reader) ReadInt32() (int32, error) {
+	b, err := r.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(b)), nil
+}
+
+// ReadInt64 reads a little-endian int64
+func (r *Reader) ReadInt64() (int64, error) {
+	b, err := r.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(b)), nil
+}
+
+// Read7BitEncodedInt reads a 32-bit integer encoded 7 bits at a time, like
+// BinaryReader.Read7BitEncodedInt. It reads at most 5 bytes and returns
+// ErrBad7BitInt if the value does not fit in 32 bits.
+func (r *Reader) Read7BitEncodedInt() (int32, error) {
+	var result uint32
+
+	for shift := 0; shift < 35; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		// The fifth byte may only contribute the upper 4 bits
+		if shift == 28 && b > 0x0F {
+			return 0, ErrBad7BitInt
+		}
+
+		result |= uint32(b&0x7F) << shift
+		if b&0x80 == 0 {
+			return int32(result), nil
+		}
+	}
+
+	return 0, ErrBad7BitInt
+}
+
+// ReadStringBytes reads the raw bytes of a length-prefixed string
+func (r *Reader) ReadStringBytes() ([]byte, error) {
+	length, err := r.Read7BitEncodedInt()
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		return nil, ErrNegativeLength
+	}
+	if r.MaxStringLength > 0 && int(length) > r.MaxStringLength {
+		return nil, ErrStringTooLong
+	}
+
+	data, err := r.ReadBytes(int(length))
+	if err == io.EOF {
+		// The length prefix promised more data
+		err = io.ErrUnexpectedEOF
+	}
+	return data, err
+}
+
+// ReadString reads a length-prefixed string, like BinaryReader.ReadString
+func (r *Reader) ReadString() (string, error) {
+	data, err := r.ReadStringBytes()
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ReadDateTime reads a DateTime stored with DateTime.ToBinary()
+func (r *Reader) ReadDateTime() (time.Time, DateTimeKind, error) {
+	value, err := r.ReadInt64()
+	if err != nil {
+		return time.Time{}, KindUnspecified, err
+	}
+	t, kind := FromBinary(value)
+	return t, kind, nil
+}
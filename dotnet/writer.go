@@ -0,0 +1,89 @@
+package dotnet
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// Writer writes .NET BinaryWriter primitives to an io.Writer. Every value
+// is written with a single call to the underlying writer, or one call per byte
+// for 7-bit encoded integers, which matters for chunked streams like XXTeaStream.
+type Writer struct {
+	w   io.Writer
+	buf [8]byte
+}
+
+// NewWriter creates a new Writer writing to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// write writes the first n bytes of the internal buffer
+func (w *Writer) write(n int) error {
+	_, err := w.w.Write(w.buf[:n])
+	return err
+}
+
+// WriteByte writes a single byte
+func (w *Writer) WriteByte(b byte) error {
+	w.buf[0] = b
+	return w.write(1)
+}
+
+// WriteBytes writes data as is
+func (w *Writer) WriteBytes(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	_, err := w.w.Write(data)
+	return err
+}
+
+// WriteInt16 writes a little-endian int16
+func (w *Writer) WriteInt16(v int16) error {
+	binary.LittleEndian.PutUint16(w.buf[:], uint16(v))
+	return w.write(2)
+}
+
+// WriteInt32 writes a little-endian int32
+func (w *Writer) WriteInt32(v int32) error {
+	binary.LittleEndian.PutUint32(w.buf[:], uint32(v))
+	return w.write(4)
+}
+
+// WriteInt64 writes a little-endian int64
+func (w *Writer) WriteInt64(v int64) error {
+	binary.LittleEndian.PutUint64(w.buf[:], uint64(v))
+	return w.write(8)
+}
+
+// Write7BitEncodedInt writes a 32-bit integer 7 bits at a time, like
+// BinaryWriter.Write7BitEncodedInt. Negative values take 5 bytes.
+func (w *Writer) Write7BitEncodedInt(v int32) error {
+	value := uint32(v)
+	for value >= 0x80 {
+		if err := w.WriteByte(byte(value | 0x80)); err != nil {
+			return err
+		}
+		value >>= 7
+	}
+	return w.WriteByte(byte(value))
+}
+
+// WriteString writes a length-prefixed string, like BinaryWriter.Write(string)
+func (w *Writer) WriteString(s string) error {
+	if err := w.Write7BitEncodedInt(int32(len(s))); err != nil {
+		return err
+	}
+	if len(s) == 0 {
+		return nil
+	}
+	_, err := w.w.Write([]byte(s))
+	return err
+}
+
+// WriteDateTime writes a DateTime the way DateTime.ToBinary() stores it
+func (w *Writer) WriteDateTime(t time.Time, kind DateTimeKind) error {
+	return w.WriteInt64(ToBinary(t, kind))
+}
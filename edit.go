@@ -0,0 +1,183 @@
+package osz2
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+var (
+	// ErrFileNotFound is returned when editing a file that is not in the package
+	ErrFileNotFound = errors.New("file not found in package")
+	// ErrFileExists is returned when adding a file that is already in the package
+	ErrFileExists = errors.New("file already exists in package")
+)
+
+// AddFile adds a file to the end of the package. Both timestamps are set to
+// the current time. The algorithm of the file hashes is unknown, it is not the
+// MD5 checksum of the content. osu! does not verify them while reading, so new
+// files get the MD5 checksum as a placeholder.
+func (p *Package) AddFile(fileName string, content []byte) error {
+	if err := p.editable(); err != nil {
+		return err
+	}
+	if _, exists := p.FileInfos[fileName]; exists {
+		return fmt.Errorf("%w: %s", ErrFileExists, fileName)
+	}
+
+	now := p.now()
+	fileInfo := NewFileInfo(fileName, 0, 0, ComputeHashBytesRaw(content), now, now)
+	p.FileInfos[fileName] = fileInfo
+	p.Files[fileName] = content
+	p.Entries = append(p.Entries, fileInfo)
+	p.updateOffsets()
+	return nil
+}
+
+// ReplaceFile replaces the content of a file and its modification time. Like
+// in AddFile, the hash is replaced with the MD5 checksum as a placeholder.
+func (p *Package) ReplaceFile(fileName string, content []byte) error {
+	if err := p.editable(); err != nil {
+		return err
+	}
+	fileInfo, exists := p.FileInfos[fileName]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, fileName)
+	}
+
+	fileInfo.Hash = ComputeHashBytesRaw(content)
+	fileInfo.DateModified = p.now()
+	fileInfo.DateModifiedKind = KindUtc
+	p.Files[fileName] = content
+	p.updateOffsets()
+	return nil
+}
+
+// RenameFile renames a file, keeping its position and beatmap id mapping
+func (p *Package) RenameFile(oldName, newName string) error {
+	if err := p.editable(); err != nil {
+		return err
+	}
+	fileInfo, exists := p.FileInfos[oldName]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, oldName)
+	}
+	if oldName == newName {
+		return nil
+	}
+	if _, exists := p.FileInfos[newName]; exists {
+		return fmt.Errorf("%w: %s", ErrFileExists, newName)
+	}
+
+	fileInfo.FileName = newName
+	p.FileInfos[newName] = fileInfo
+	p.Files[newName] = p.Files[oldName]
+	delete(p.FileInfos, oldName)
+	delete(p.Files, oldName)
+	p.updateOffsets()
+
+	if beatmapID, ok := p.FileNames[oldName]; ok {
+		p.FileNames[newName] = beatmapID
+		p.FileIDs[beatmapID] = newName
+		delete(p.FileNames, oldName)
+		replaceName(p.fileNameOrder, oldName, newName)
+	}
+	return nil
+}
+
+// RemoveFile removes a file and its beatmap id mapping
+func (p *Package) RemoveFile(fileName string) error {
+	if err := p.editable(); err != nil {
+		return err
+	}
+	if _, exists := p.FileInfos[fileName]; !exists {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, fileName)
+	}
+
+	delete(p.FileInfos, fileName)
+	delete(p.Files, fileName)
+	p.RemoveBeatmapID(fileName)
+	p.updateOffsets()
+	return nil
+}
+
+// SetBeatmapID maps a file to a beatmap id, replacing any previous
+// mapping of the file and of the beatmap id
+func (p *Package) SetBeatmapID(fileName string, beatmapID int32) {
+	if previousID, ok := p.FileNames[fileName]; ok {
+		delete(p.FileIDs, previousID)
+	}
+	if previousName, ok := p.FileIDs[beatmapID]; ok {
+		delete(p.FileNames, previousName)
+	}
+
+	if _, ok := p.FileNames[fileName]; !ok && !slices.Contains(p.fileNameOrder, fileName) {
+		p.fileNameOrder = append(p.fileNameOrder, fileName)
+	}
+	p.FileNames[fileName] = beatmapID
+	p.FileIDs[beatmapID] = fileName
+}
+
+// RemoveBeatmapID removes the beatmap id mapping of a file
+func (p *Package) RemoveBeatmapID(fileName string) {
+	beatmapID, ok := p.FileNames[fileName]
+	if !ok {
+		return
+	}
+	delete(p.FileNames, fileName)
+	if p.FileIDs[beatmapID] == fileName {
+		delete(p.FileIDs, beatmapID)
+	}
+}
+
+// editable makes sure the file contents are available for editing
+func (p *Package) editable() error {
+	if p.metadataOnly {
+		return p.loadFiles()
+	}
+	return nil
+}
+
+// updateOffsets rebuilds the entries in the order they will be written in,
+// dropping removed files and duplicates, and recalculates their offsets and sizes
+func (p *Package) updateOffsets() {
+	entries := make([]*FileInfo, 0, len(p.Files))
+
+	var offset int32
+	for _, fileName := range orderedKeys(p.Files, p.entryNames()) {
+		size := 4 + int32(len(p.Files[fileName]))
+		if fileInfo, ok := p.FileInfos[fileName]; ok {
+			fileInfo.Offset = offset
+			fileInfo.Size = size
+			fileInfo.ContentLength = size - 4
+			entries = append(entries, fileInfo)
+		}
+		offset += size
+	}
+
+	p.Entries = entries
+}
+
+// entryNames returns the names of the entries in order, without duplicates
+func (p *Package) entryNames() []string {
+	names := make([]string, 0, len(p.Entries))
+	for _, fileInfo := range p.Entries {
+		if p.FileInfos[fileInfo.FileName] == fileInfo {
+			names = append(names, fileInfo.FileName)
+		}
+	}
+	return names
+}
+
+// now returns the current time as it is stored in a package
+func (p *Package) now() time.Time {
+	return time.Now().UTC().Truncate(100 * time.Nanosecond)
+}
+
+// replaceName replaces the first occurrence of oldName in names
+func replaceName(names []string, oldName, newName string) {
+	if i := slices.Index(names, oldName); i >= 0 {
+		names[i] = newName
+	}
+}
@@ -7,12 +7,29 @@ type FileInfo struct {
 	FileName     string
 	Offset       int32
 	Size         int32
-	Hash         []byte
 	DateCreated  time.Time
 	DateModified time.Time
+
+	// Hash is the 16 byte hash of the file stored in the file table. The
+	// algorithm osu! computes it with is unknown, so it is not verified
+	// when reading. Files added or replaced with AddFile and ReplaceFile
+	// get the MD5 checksum of their content instead, as a placeholder.
+	Hash []byte
+
+	// ContentLength is the length of the file contents, as stored in the
+	// encrypted prefix of the body. Size includes the 4 byte prefix.
+	ContentLength int32
+
+	// DateCreatedKind and DateModifiedKind are the .NET DateTime kinds
+	// the timestamps are stored with
+	DateCreatedKind  DateTimeKind
+	DateModifiedKind DateTimeKind
+
+	// Original bytes of the file name, if invalid UTF-8 in it was replaced
+	rawName rawString
 }
 
-// NewFileInfo creates a new FileInfo instance
+// NewFileInfo creates a new FileInfo instance with timestamps of kind Utc
 func NewFileInfo(fileName string, offset, size int32, hash []byte, dateCreated, dateModified time.Time) *FileInfo {
 	return &FileInfo{
 		FileName:     fileName,
@@ -21,5 +38,9 @@ func NewFileInfo(fileName string, offset, size int32, hash []byte, dateCreated,
 		Hash:         hash,
 		DateCreated:  dateCreated,
 		DateModified: dateModified,
+
+		ContentLength:    max(size-4, 0),
+		DateCreatedKind:  KindUtc,
+		DateModifiedKind: KindUtc,
 	}
 }
@@ -1,3 +1,5 @@
 module github.com/Lekuruu/osz2-go
 
 go 1.21
+
+require golang.org/x/text v0.22.0
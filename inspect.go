@@ -0,0 +1,359 @@
+package osz2
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Inspection describes the layout of an osz2 package, section by section.
+// All offsets are absolute positions in the package.
+type Inspection struct {
+	// Size is the size of the package in bytes
+	Size int64
+
+	// Header fields
+	Identifier []byte
+	Version    byte
+	IV         []byte
+
+	// Hashes stored in the header and computed from the sections
+	MetaDataHash HashCheck
+	FileInfoHash HashCheck
+	FullBodyHash HashCheck
+
+	// MetadataOffset is the offset of the metadata section
+	MetadataOffset int64
+	Metadata       []MetadataEntry
+
+	// FileNamesOffset is the offset of the file name mapping
+	FileNamesOffset int64
+	FileNames       []FileNameEntry
+
+	// MagicOffset is the offset of the encrypted magic block
+	MagicOffset    int64
+	Magic          []byte
+	DecryptedMagic []byte
+
+	// Key is the key the package was inspected with, and
+	// KeyValid reports whether it decrypts the magic block
+	Key      []byte
+	KeyValid bool
+
+	// FileInfoLengthOffset is the offset of the encoded file info length
+	FileInfoLengthOffset  int64
+	EncodedFileInfoLength int32
+	FileInfoLength        int32
+
+	// FileInfoOffset is the offset of the encrypted file info. The
+	// entries are only decrypted if KeyValid is set.
+	FileInfoOffset int64
+	Entries        []EntryInspection
+
+	// DataOffset is the offset of the first file body
+	DataOffset int64
+
+	// TrailingOffset is the offset of the data following the last file
+	// body, and TrailingBytes its length. TrailingBytes is negative if
+	// the package is truncated. Both are only set if KeyValid is set.
+	TrailingOffset int64
+	TrailingBytes  int64
+}
+
+// HashCheck contains a hash stored in the header and the hash computed
+// from the package. Computed is nil if the section could not be read.
+type HashCheck struct {
+	Stored   []byte
+	Computed []byte
+}
+
+// Match reports whether the computed hash matches the stored one
+func (h HashCheck) Match() bool {
+	return h.Computed != nil && bytes.Equal(h.Stored, h.Computed)
+}
+
+// MetadataEntry is an entry of the metadata section
+type MetadataEntry struct {
+	Offset int64
+	Type   MetaType
+	Value  string
+}
+
+// FileNameEntry is an entry of the file name mapping
+type FileNameEntry struct {
+	Offset    int64
+	FileName  string
+	BeatmapID int32
+}
+
+// EntryInspection is an entry of the file info table
+type EntryInspection struct {
+	FileName     string
+	Hash         []byte
+	DateCreated  time.Time
+	DateModified time.Time
+
+	// Offset is the offset stored in the table, relative to the data offset
+	Offset int32
+
+	// AbsoluteOffset is the offset of the body in the package
+	AbsoluteOffset int64
+
+	// TableSize is the size following from the offsets in the table,
+	// or -1 for the last entry, whose size is not stored
+	TableSize int32
+
+	// PrefixLength is the length decrypted from the body prefix,
+	// or -1 if the prefix is not present
+	PrefixLength int64
+}
+
+// Inspect reads the layout of a package without interpreting it, to debug
+// packages that NewPackage rejects. The key is determined like NewPackage does,
+// using the given options. Inspect continues after failed checks and returns
+// everything that was read, together with the error that stopped it, if any.
+// The file info and the file bodies are only decrypted if the key is valid.
+func Inspect(r io.ReadSeeker, options ...Option) (*Inspection, error) {
+	p := &Package{
+		Metadata:  make(map[MetaType]string),
+		FileInfos: make(map[string]*FileInfo),
+		Files:     make(map[string][]byte),
+		FileNames: make(map[string]int32),
+		FileIDs:   make(map[int32]string),
+	}
+	for _, option := range options {
+		option(p)
+	}
+
+	inspection := &Inspection{}
+	err := inspection.read(r, p)
+	return inspection, err
+}
+
+// read reads the sections of the package into the inspection
+func (inspection *Inspection) read(r io.ReadSeeker, p *Package) error {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	inspection.Size = size
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	counter := &offsetReader{reader: r, size: size}
+	reader := newReader(counter)
+
+	// Header
+	if inspection.Identifier, err = reader.ReadBytes(len(fileIdentifier)); err != nil {
+		return fmt.Errorf("header: %w", err)
+	}
+	if inspection.Version, err = reader.ReadByte(); err != nil {
+		return fmt.Errorf("header: %w", err)
+	}
+	if inspection.IV, err = reader.ReadBytes(IVSize); err != nil {
+		return fmt.Errorf("header: %w", err)
+	}
+	for _, hash := range []*HashCheck{&inspection.MetaDataHash, &inspection.FileInfoHash, &inspection.FullBodyHash} {
+		if hash.Stored, err = reader.ReadBytes(16); err != nil {
+			return fmt.Errorf("header: %w", err)
+		}
+	}
+
+	// Metadata, hashed as it is read
+	inspection.MetadataOffset = counter.offset
+	var metadata bytes.Buffer
+	metadataReader := newReader(&io.LimitedReader{R: io.TeeReader(counter, &metadata), N: counter.size - counter.offset})
+
+	count, err := metadataReader.ReadInt32()
+	if err != nil {
+		return fmt.Errorf("metadata: %w", err)
+	}
+	for i := int32(0); i < count; i++ {
+		entry := MetadataEntry{Offset: counter.offset}
+		metaType, err := metadataReader.ReadInt16()
+		if err != nil {
+			return fmt.Errorf("metadata entry %d: %w", i, err)
+		}
+		if entry.Value, err = metadataReader.ReadString(); err != nil {
+			return fmt.Errorf("metadata entry %d: %w", i, err)
+		}
+		entry.Type = MetaType(metaType)
+		inspection.Metadata = append(inspection.Metadata, entry)
+		p.Metadata[entry.Type] = entry.Value
+	}
+	inspection.MetaDataHash.Computed = computeOszHash(metadata.Bytes(), int(count)*3, 0xa7)
+
+	// File name mapping
+	inspection.FileNamesOffset = counter.offset
+	if count, err = reader.ReadInt32(); err != nil {
+		return fmt.Errorf("file names: %w", err)
+	}
+	for i := int32(0); i < count; i++ {
+		entry := FileNameEntry{Offset: counter.offset}
+		if entry.FileName, err = reader.ReadString(); err != nil {
+			return fmt.Errorf("file name entry %d: %w", i, err)
+		}
+		if entry.BeatmapID, err = reader.ReadInt32(); err != nil {
+			return fmt.Errorf("file name entry %d: %w", i, err)
+		}
+		inspection.FileNames = append(inspection.FileNames, entry)
+	}
+
+	// Magic block
+	inspection.MagicOffset = counter.offset
+	if inspection.Magic, err = reader.ReadBytes(len(magicPlaintext)); err != nil {
+		return fmt.Errorf("magic: %w", err)
+	}
+	p.magic = inspection.Magic
+
+	// The sections up to the file bodies are read without a key, so a
+	// failure to determine it is only returned after the body hash
+	key, keyErr := p.resolveKey()
+	if keyErr == nil {
+		inspection.Key = key
+		inspection.KeyValid = validKey(inspection.Magic, key)
+		inspection.DecryptedMagic = bytes.Clone(inspection.Magic)
+		NewXTEA(bytesToUint32Array(key)).Decrypt(inspection.DecryptedMagic, 0, len(inspection.DecryptedMagic))
+	}
+
+	// File info length, encoded with the file info hash
+	inspection.FileInfoLengthOffset = counter.offset
+	if inspection.EncodedFileInfoLength, err = reader.ReadInt32(); err != nil {
+		return fmt.Errorf("file info length: %w", err)
+	}
+	length := inspection.EncodedFileInfoLength
+	hash := inspection.FileInfoHash.Stored
+	for i := 0; i < 16; i += 2 {
+		length -= int32(hash[i]) | (int32(hash[i+1]) << 17)
+	}
+	inspection.FileInfoLength = length
+
+	inspection.FileInfoOffset = counter.offset
+	if length < 0 || int64(length) > size-counter.offset {
+		return fmt.Errorf("file info length %d exceeds the package", length)
+	}
+	fileInfo, err := reader.ReadBytes(int(length))
+	if err != nil {
+		return fmt.Errorf("file info: %w", err)
+	}
+	inspection.DataOffset = counter.offset
+
+	// Body hash over everything following the file info
+	if _, err := r.Seek(inspection.DataOffset, io.SeekStart); err != nil {
+		return err
+	}
+	hasher := newOszHasher(int(size-inspection.DataOffset)/2, 0x9f)
+	if _, err := io.Copy(hasher, r); err != nil {
+		return err
+	}
+	inspection.FullBodyHash.Computed = hasher.Sum()
+
+	if keyErr != nil {
+		return fmt.Errorf("key: %w", keyErr)
+	}
+
+	// Decrypting with a wrong key results in meaningless entries
+	if !inspection.KeyValid {
+		return nil
+	}
+	xxtea := NewXXTEA(bytesToUint32Array(key))
+	if err := inspection.readEntries(fileInfo, xxtea); err != nil {
+		return fmt.Errorf("file info: %w", err)
+	}
+	return inspection.readPrefixes(r, xxtea)
+}
+
+// readEntries decrypts and parses the file info table
+func (inspection *Inspection) readEntries(fileInfo []byte, xxtea *XXTEA) error {
+	reader := newReader(&io.LimitedReader{R: NewXXTEAReaderWithCipher(bytes.NewReader(fileInfo), xxtea), N: int64(len(fileInfo))})
+
+	count, err := reader.ReadInt32()
+	if err != nil {
+		return err
+	}
+	inspection.FileInfoHash.Computed = computeOszHash(fileInfo, int(count)*4, 0xd1)
+
+	// The file info of a package without files ends after the count
+	if count <= 0 {
+		return nil
+	}
+
+	offset, err := reader.ReadInt32()
+	if err != nil {
+		return err
+	}
+
+	for i := int32(0); i < count; i++ {
+		entry := EntryInspection{
+			Offset:         offset,
+			AbsoluteOffset: inspection.DataOffset + int64(offset),
+			TableSize:      -1,
+			PrefixLength:   -1,
+		}
+		if entry.FileName, err = reader.ReadString(); err != nil {
+			return err
+		}
+		if entry.Hash, err = reader.ReadBytes(16); err != nil {
+			return err
+		}
+		if entry.DateCreated, _, err = reader.ReadDateTime(); err != nil {
+			return err
+		}
+		if entry.DateModified, _, err = reader.ReadDateTime(); err != nil {
+			return err
+		}
+		if i+1 < count {
+			if offset, err = reader.ReadInt32(); err != nil {
+				return err
+			}
+			entry.TableSize = offset - entry.Offset
+		}
+		inspection.Entries = append(inspection.Entries, entry)
+	}
+	return nil
+}
+
+// readPrefixes decrypts the length prefix of every file body
+// and determines where the trailing data begins
+func (inspection *Inspection) readPrefixes(r io.ReadSeeker, xxtea *XXTEA) error {
+	inspection.TrailingOffset = inspection.DataOffset
+
+	for i := range inspection.Entries {
+		entry := &inspection.Entries[i]
+		if entry.AbsoluteOffset < inspection.DataOffset || entry.AbsoluteOffset+4 > inspection.Size {
+			continue
+		}
+		osz2Reader, err := NewOsz2ReaderWithCipher(r, int(entry.AbsoluteOffset), xxtea)
+		if err != nil {
+			return fmt.Errorf("prefix of %s: %w", entry.FileName, err)
+		}
+		entry.PrefixLength = int64(osz2Reader.Length())
+		inspection.TrailingOffset = max(inspection.TrailingOffset, entry.AbsoluteOffset+4+entry.PrefixLength)
+	}
+
+	inspection.TrailingBytes = inspection.Size - inspection.TrailingOffset
+	return nil
+}
+
+// offsetReader tracks the offset of the underlying reader of the given size
+type offsetReader struct {
+	reader io.Reader
+	offset int64
+	size   int64
+}
+
+// Read reads from the underlying reader
+func (o *offsetReader) Read(p []byte) (int, error) {
+	n, err := o.reader.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+// Len returns the number of bytes left, which the dotnet.Reader checks before
+// allocating values with a length prefix
+func (o *offsetReader) Len() int {
+	return int(min(o.size-o.offset, math.MaxInt))
+}
@@ -0,0 +1,113 @@
+package osz2
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestInspect tests inspecting intact, damaged and truncated packages, and
+// packages whose key is wrong or cannot be determined
+func TestInspect(t *testing.T) {
+	data, pkg := readTestPackage(t, "tests/Karoo13 - Tic Tac Toe.osz2", false)
+
+	inspection, err := Inspect(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to inspect package: %v", err)
+	}
+	if !inspection.MetaDataHash.Match() || !inspection.FileInfoHash.Match() || !inspection.FullBodyHash.Match() {
+		t.Errorf("Expected all hashes to match, got %+v %+v %+v", inspection.MetaDataHash, inspection.FileInfoHash, inspection.FullBodyHash)
+	}
+	if !inspection.KeyValid || !bytes.Equal(inspection.DecryptedMagic, magicPlaintext[:]) {
+		t.Error("Expected the derived key to decrypt the magic block")
+	}
+	if len(inspection.Metadata) != len(pkg.Metadata) || len(inspection.FileNames) != len(pkg.FileNames) {
+		t.Errorf("Expected %d metadata entries and %d file names, got %d and %d",
+			len(pkg.Metadata), len(pkg.FileNames), len(inspection.Metadata), len(inspection.FileNames))
+	}
+	if inspection.DataOffset != pkg.fileOffset || inspection.TrailingBytes != 0 {
+		t.Errorf("Expected data at %d without trailing bytes, got %d and %d",
+			pkg.fileOffset, inspection.DataOffset, inspection.TrailingBytes)
+	}
+	if len(inspection.Entries) != len(pkg.Entries) {
+		t.Fatalf("Expected %d entries, got %d", len(pkg.Entries), len(inspection.Entries))
+	}
+	for i, entry := range inspection.Entries {
+		fileInfo := pkg.Entries[i]
+		if entry.FileName != fileInfo.FileName || entry.PrefixLength != int64(fileInfo.ContentLength) {
+			t.Errorf("Entry %d: expected %s with length %d, got %s with length %d",
+				i, fileInfo.FileName, fileInfo.ContentLength, entry.FileName, entry.PrefixLength)
+		}
+		if i < len(inspection.Entries)-1 && entry.TableSize != fileInfo.Size {
+			t.Errorf("Entry %d: expected table size %d, got %d", i, fileInfo.Size, entry.TableSize)
+		}
+	}
+
+	// A truncated package is inspected completely, but misses bytes
+	inspection, err = Inspect(bytes.NewReader(data[:len(data)-100]))
+	if err != nil {
+		t.Fatalf("Failed to inspect truncated package: %v", err)
+	}
+	if inspection.TrailingBytes != -100 || inspection.FullBodyHash.Match() {
+		t.Errorf("Expected 100 missing bytes and a body hash mismatch, got %d", inspection.TrailingBytes)
+	}
+
+	// Damaged metadata is reported instead of stopping the inspection
+	damaged := bytes.Clone(data)
+	i := bytes.Index(damaged, []byte(pkg.Metadata[Title]))
+	damaged[i] ^= 0x20
+	inspection, err = Inspect(bytes.NewReader(damaged))
+	if err != nil {
+		t.Fatalf("Failed to inspect damaged package: %v", err)
+	}
+	if inspection.MetaDataHash.Match() || !inspection.FullBodyHash.Match() {
+		t.Error("Expected only the metadata hash to mismatch")
+	}
+
+	// Sections that cannot be read stop the inspection, keeping what was read
+	inspection, err = Inspect(bytes.NewReader(data[:int(pkg.fileOffset)-10]))
+	if err == nil {
+		t.Fatal("Expected an error for a package cut off inside the file info")
+	}
+	if len(inspection.Metadata) != len(pkg.Metadata) || !inspection.KeyValid {
+		t.Error("Expected the sections before the file info to be read")
+	}
+
+	// A wrong key leaves the file info encrypted, but the body is still hashed
+	inspection, err = Inspect(bytes.NewReader(data), WithKey(make([]byte, 16)))
+	if err != nil {
+		t.Fatalf("Failed to inspect package with a wrong key: %v", err)
+	}
+	if inspection.KeyValid || inspection.Entries != nil || inspection.FileInfoHash.Computed != nil {
+		t.Error("Expected the file info not to be decrypted with a wrong key")
+	}
+	if inspection.DataOffset != pkg.fileOffset || !inspection.FullBodyHash.Match() {
+		t.Error("Expected the body to be hashed with a wrong key")
+	}
+
+	// So is the body of a package whose key cannot be determined
+	inspection, err = Inspect(bytes.NewReader(removeMetadata(t, data, Creator)))
+	if err == nil {
+		t.Fatal("Expected an error for a package without a key")
+	}
+	if inspection.Entries != nil || !inspection.FullBodyHash.Match() {
+		t.Error("Expected only the body to be hashed without a key")
+	}
+
+	// A package without files has no entries and no trailing data
+	for _, fileInfo := range pkg.Entries {
+		if err := pkg.RemoveFile(fileInfo.FileName); err != nil {
+			t.Fatalf("Failed to remove file: %v", err)
+		}
+	}
+	var empty bytes.Buffer
+	if _, err := pkg.WriteTo(&empty); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	inspection, err = Inspect(bytes.NewReader(empty.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to inspect package without files: %v", err)
+	}
+	if len(inspection.Entries) != 0 || inspection.TrailingBytes != 0 || !inspection.FileInfoHash.Match() {
+		t.Errorf("Expected no entries and no trailing bytes, got %d and %d", len(inspection.Entries), inspection.TrailingBytes)
+	}
+}
@@ -0,0 +1,141 @@
+package osz2
+
+import (
+	"bytes"
+	"errors"
+)
+
+// KeySize is the size of an osz2 package key
+const KeySize = 16
+
+// keySalt is placed between the creator and the beatmap set id during key derivation
+const keySalt = "yhxyfjo5"
+
+// ErrWrongKey is returned when the package key does not decrypt the magic block
+var ErrWrongKey = errors.New("wrong package key: magic block does not match")
+
+// KeyFunc returns the key of a package based on its metadata. The metadata
+// is passed as it was read, with the original bytes of values whose invalid
+// UTF-8 was replaced because of UTF8Replace, which the key is derived from.
+type KeyFunc func(metadata map[MetaType]string) ([]byte, error)
+
+// KeyCandidate is a creator and beatmap set id pair that may have been used
+// to derive the key of a package whose metadata is missing or was altered
+type KeyCandidate struct {
+	Creator      string
+	BeatmapSetID string
+}
+
+// Key derives the package key of the candidate
+func (c KeyCandidate) Key() []byte {
+	return DeriveKey(c.Creator, c.BeatmapSetID)
+}
+
+// magicPlaintext is the content of the XTEA-encrypted block following the
+// filename mapping. Only the correct key decrypts the block to these bytes.
+var magicPlaintext = [64]byte{
+	0x55, 0xaa, 0x74, 0x10, 0x2b, 0x56, 0xb3, 0x9e, 0x25, 0x9e, 0xfe, 0xb7, 0xbe, 0x06, 0xfc, 0xf2,
+	0xb6, 0x3c, 0x6f, 0x47, 0x7e, 0x38, 0x69, 0x43, 0x80, 0x89, 0x25, 0x00, 0xcc, 0xb6, 0xfe, 0x12,
+	0xa9, 0xb2, 0x4a, 0x2c, 0x96, 0xd5, 0xea, 0x26, 0x42, 0x31, 0xaf, 0x0a, 0x0d, 0xae, 0x00, 0xed,
+	0xfe, 0x96, 0xa6, 0x94, 0x99, 0xa7, 0x90, 0xe4, 0x68, 0xbf, 0xc6, 0x97, 0x5b, 0x1b, 0x5e, 0x7f,
+}
+
+// validKey reports whether key decrypts the encrypted magic block to its known plaintext
+func validKey(encryptedMagic []byte, key []byte) bool {
+	if len(encryptedMagic) != len(magicPlaintext) || len(key) != KeySize {
+		return false
+	}
+
+	plain := make([]byte, len(encryptedMagic))
+	copy(plain, encryptedMagic)
+	NewXTEA(bytesToUint32Array(key)).Decrypt(plain, 0, len(plain))
+
+	return bytes.Equal(plain, magicPlaintext[:])
+}
+
+// DeriveKey derives the package key from the creator and beatmap set id,
+// the way osu! does it: MD5(creator + "yhxyfjo5" + beatmapSetID)
+func DeriveKey(creator, beatmapSetID string) []byte {
+	return ComputeHashBytesRaw([]byte(creator + keySalt + beatmapSetID))
+}
+
+// DeriveKeyFromMetadata derives the package key from the Creator and BeatmapSetID metadata
+func DeriveKeyFromMetadata(metadata map[MetaType]string) ([]byte, error) {
+	creator, ok_creator := metadata[Creator]
+	beatmapSetID, ok_setID := metadata[BeatmapSetID]
+
+	if !ok_creator || !ok_setID {
+		return nil, errors.New("missing required metadata for key generation")
+	}
+
+	return DeriveKey(creator, beatmapSetID), nil
+}
+
+// keyMetadata returns the metadata values the key is derived from
+func keyMetadata(metadata map[MetaType]string) map[MetaType]string {
+	values := make(map[MetaType]string, 2)
+	for _, metaType := range []MetaType{Creator, BeatmapSetID} {
+		if value, ok := metadata[metaType]; ok {
+			values[metaType] = value
+		}
+	}
+	return values
+}
+
+// resolveKey determines the key of the package, preferring an
+// explicit key or key function over the metadata
+func (p *Package) resolveKey() ([]byte, error) {
+	var key []byte
+	var err error
+
+	switch {
+	case p.keyFunc != nil:
+		key, err = p.keyFunc(p.originalMetadata())
+	case p.key != nil:
+		key = p.key
+	case len(p.keyCandidates) > 0:
+		key, err = p.recoverKey()
+	default:
+		key, err = DeriveKeyFromMetadata(p.originalMetadata())
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != KeySize {
+		return nil, errors.New("invalid package key length")
+	}
+	return key, nil
+}
+
+// recoverKey finds the key that decrypts the magic block, trying the
+// key derived from the metadata first and then every key candidate
+func (p *Package) recoverKey() ([]byte, error) {
+	if key, err := DeriveKeyFromMetadata(p.originalMetadata()); err == nil && validKey(p.magic, key) {
+		return key, nil
+	}
+
+	for i := range p.keyCandidates {
+		candidate := p.keyCandidates[i]
+		key := candidate.Key()
+
+		if validKey(p.magic, key) {
+			p.MatchedCandidate = &candidate
+			return key, nil
+		}
+	}
+
+	return nil, errors.New("none of the key candidates matched")
+}
+
+// KeyValid reports whether the package key decrypts the magic block correctly.
+// Packages opened in metadata-only mode are not checked while reading,
+// so this can be used to verify the key without decrypting any files.
+func (p *Package) KeyValid() bool {
+	return validKey(p.magic, p.key)
+}
+
+// Key returns a copy of the key used to decrypt the package
+func (p *Package) Key() []byte {
+	return append([]byte(nil), p.key...)
+}
@@ -0,0 +1,55 @@
+package osz2
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeName normalizes a file name for comparison: backslashes are
+// replaced with slashes, the case is folded and the name is composed to
+// Unicode NFC, as names written on macOS are decomposed. Names that osu!
+// considers the same file normalize to the same string.
+func NormalizeName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	return norm.NFC.String(cases.Fold().String(norm.NFD.String(name)))
+}
+
+// Lookup returns the file info of a file, ignoring differences in case, path
+// separators and Unicode normalization. An exact match is preferred, otherwise
+// the first matching entry in the order of the package is returned.
+func (p *Package) Lookup(name string) (*FileInfo, bool) {
+	if fileInfo, ok := p.FileInfos[name]; ok {
+		return fileInfo, true
+	}
+	fileName, ok := findName(p.FileInfos, p.entryNames(), name)
+	if !ok {
+		return nil, false
+	}
+	return p.FileInfos[fileName], true
+}
+
+// BeatmapID returns the beatmap id of a file, matching the file
+// name the same way as Lookup
+func (p *Package) BeatmapID(name string) (int32, bool) {
+	if beatmapID, ok := p.FileNames[name]; ok {
+		return beatmapID, true
+	}
+	fileName, ok := findName(p.FileNames, p.fileNameOrder, name)
+	if !ok {
+		return 0, false
+	}
+	return p.FileNames[fileName], true
+}
+
+// findName returns the first key of m in the given order that normalizes like name
+func findName[V any](m map[string]V, order []string, name string) (string, bool) {
+	normalized := NormalizeName(name)
+	for _, fileName := range orderedKeys(m, order) {
+		if NormalizeName(fileName) == normalized {
+			return fileName, true
+		}
+	}
+	return "", false
+}
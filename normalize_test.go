@@ -0,0 +1,116 @@
+package osz2
+
+import (
+	"testing"
+	"time"
+)
+
+// TestNormalizeName tests that names osu! considers the same file normalize equally
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"SB\\Background.PNG", "sb/background.png"},
+		{"Caf\u00e9.mp3", "CAFE\u0301.MP3"},
+		{"\u30d2\u3099\u30fc\u30c8.wav", "\u30d3\u30fc\u30c8.wav"},
+		{"\u304b\u3099\u306f\u309a", "\u304c\u3071"},
+		{"\u212a.png", "k.png"},
+		{"\ud55c.osu", "\u1112\u1161\u11ab.osu"},  // 한, Hangul jamo
+		{"\u1ebf.mp3", "E\u0302\u0301.mp3"},       // ế, two combining marks
+		{"\u1ea1.mp3", "a\u0323.mp3"},             // ạ
+		{"\u01ce.png", "A\u030c.png"},             // ǎ
+		{"o\u0301\u0328.png", "\u01eb\u0301.png"}, // ǫ́, marks in a different order
+		{"Stra\u00dfe.osu", "STRASSE.osu"},
+	}
+	for _, test := range tests {
+		if NormalizeName(test.a) != NormalizeName(test.b) {
+			t.Errorf("%q and %q normalize to %q and %q", test.a, test.b, NormalizeName(test.a), NormalizeName(test.b))
+		}
+	}
+
+	if NormalizeName("a/b.png") == NormalizeName("ab.png") {
+		t.Error("Different names normalize equally")
+	}
+	if NormalizeName("\u3099a") != "\u3099a" {
+		t.Error("A combining mark without a base was changed")
+	}
+	if NormalizeName("\u3042\u3099") != "\u3042\u3099" {
+		t.Error("A sequence without a composed character was changed")
+	}
+	if NormalizeName("E\u0302\u0301") != "\u1ebf" {
+		t.Errorf("NormalizeName did not compose to NFC: %q", NormalizeName("E\u0302\u0301"))
+	}
+}
+
+// TestLookup tests looking up files with differently formatted names
+func TestLookup(t *testing.T) {
+	pkg := NewEmptyPackage()
+	pkg.Metadata[Creator] = "peppy"
+	pkg.Metadata[BeatmapSetID] = "1"
+	for _, name := range []string{"SB\\Layer.png", "sb/layer.png", "Map [Hard].osu"} {
+		if err := pkg.AddFile(name, []byte(name)); err != nil {
+			t.Fatalf("Failed to add %s: %v", name, err)
+		}
+	}
+	pkg.SetBeatmapID("Map [Hard].osu", 42)
+
+	tests := []struct {
+		name, expected string
+	}{
+		{"sb/layer.png", "sb/layer.png"},
+		{"SB/LAYER.PNG", "SB\\Layer.png"},
+		{"map [hard].OSU", "Map [Hard].osu"},
+	}
+	for _, test := range tests {
+		fileInfo, ok := pkg.Lookup(test.name)
+		if !ok {
+			t.Errorf("Lookup(%q) found nothing", test.name)
+		} else if fileInfo.FileName != test.expected {
+			t.Errorf("Lookup(%q) = %s, expected %s", test.name, fileInfo.FileName, test.expected)
+		}
+	}
+
+	if _, ok := pkg.Lookup("missing.png"); ok {
+		t.Error("Lookup found a missing file")
+	}
+	if beatmapID, ok := pkg.BeatmapID("MAP [HARD].osu"); !ok || beatmapID != 42 {
+		t.Errorf("BeatmapID = %d, %v, expected 42", beatmapID, ok)
+	}
+
+	// Edits are noticed
+	if err := pkg.RenameFile("Map [Hard].osu", "Map [Insane].osu"); err != nil {
+		t.Fatalf("Failed to rename file: %v", err)
+	}
+	if err := pkg.RemoveFile("SB\\Layer.png"); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if _, ok := pkg.Lookup("map [hard].osu"); ok {
+		t.Error("Lookup found a renamed file under its old name")
+	}
+	if fileInfo, ok := pkg.Lookup("MAP [INSANE].OSU"); !ok || fileInfo.FileName != "Map [Insane].osu" {
+		t.Error("Lookup did not find a renamed file")
+	}
+	if fileInfo, ok := pkg.Lookup("SB/LAYER.PNG"); !ok || fileInfo.FileName != "sb/layer.png" {
+		t.Error("Lookup did not find the remaining file after a removal")
+	}
+	if beatmapID, ok := pkg.BeatmapID("map [insane].osu"); !ok || beatmapID != 42 {
+		t.Errorf("BeatmapID = %d, %v after renaming, expected 42", beatmapID, ok)
+	}
+
+	// Files added to or deleted from the map directly are noticed
+	pkg.FileInfos["Video.AVI"] = NewFileInfo("Video.AVI", 0, 0, nil, time.Time{}, time.Time{})
+	if fileInfo, ok := pkg.Lookup("video.avi"); !ok || fileInfo.FileName != "Video.AVI" {
+		t.Error("Lookup did not find a file added to the map")
+	}
+	delete(pkg.FileInfos, "sb/layer.png")
+	if _, ok := pkg.Lookup("sb/LAYER.png"); ok {
+		t.Error("Lookup found a file deleted from the map")
+	}
+
+	// Replacing a file in the map directly, keeping the number of files
+	delete(pkg.FileInfos, "Video.AVI")
+	pkg.FileInfos["Y.png"] = NewFileInfo("Y.png", 0, 0, nil, time.Time{}, time.Time{})
+	if fileInfo, ok := pkg.Lookup("y.PNG"); !ok || fileInfo.FileName != "Y.png" {
+		t.Error("Lookup did not find a file replacing another one in the map")
+	}
+}
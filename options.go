@@ -0,0 +1,67 @@
+package osz2
+
+// Option configures how NewPackage reads a package
+type Option func(*Package)
+
+// WithKey decrypts the package with an explicit key instead of
+// deriving it from the Creator and BeatmapSetID metadata
+func WithKey(key []byte) Option {
+	return func(p *Package) {
+		p.key = append([]byte(nil), key...)
+	}
+}
+
+// WithKeyFunc decrypts the package with the key returned by fn,
+// which is called once the metadata has been read
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(p *Package) {
+		p.keyFunc = fn
+	}
+}
+
+// WithKeyCandidates tries to decrypt the package with each of the given
+// creator and beatmap set id pairs, for packages where that metadata is
+// missing or wrong. The key derived from the metadata is tried first.
+// The matching candidate is reported in Package.MatchedCandidate.
+func WithKeyCandidates(candidates ...KeyCandidate) Option {
+	return func(p *Package) {
+		p.keyCandidates = append(p.keyCandidates, candidates...)
+	}
+}
+
+// WithUnknownVersions reads packages with an unknown format version as if
+// they had the known one, instead of failing with ErrUnknownVersion.
+// The version mismatch is recorded in Package.Warnings.
+func WithUnknownVersions() Option {
+	return func(p *Package) {
+		p.allowUnknownVersion = true
+	}
+}
+
+// WithStrictSizes fails with a SizeMismatchError for files whose size in the
+// file table disagrees with the length prefix of their body, instead of
+// recording the mismatch in Package.Warnings and trusting the prefix.
+func WithStrictSizes() Option {
+	return func(p *Package) {
+		p.strictSizes = true
+	}
+}
+
+// WithUTF8Policy sets how strings with invalid UTF-8 in the metadata, file
+// name mapping and file info are read. The default is UTF8Keep.
+func WithUTF8Policy(policy UTF8Policy) Option {
+	return func(p *Package) {
+		p.utf8Policy = policy
+	}
+}
+
+// WithSalvage reads as much as possible of a damaged or truncated package.
+// Failed hash and consistency checks are recorded instead of aborting, and
+// every file whose body is completely present is read. Package.Salvage
+// reports what was recovered, what was truncated and which checks failed.
+// NewPackage only fails if the header cannot be read.
+func WithSalvage() Option {
+	return func(p *Package) {
+		p.Salvage = &SalvageReport{}
+	}
+}
@@ -3,10 +3,13 @@ package osz2
 import (
 	"encoding/binary"
 	"io"
-	"math"
 )
 
-// Osz2Reader provides decryption for osz2 file contents
+// Osz2Reader provides decryption for osz2 file contents.
+// An Osz2Reader seeks the underlying reader and keeps its own block buffer,
+// so it must not be shared between goroutines. Readers are cheap to create:
+// use NewOsz2ReaderWithCipher with a shared XXTEA instance and a separate
+// underlying reader (e.g. an io.SectionReader) per goroutine.
 type Osz2Reader struct {
 	reader     io.ReadSeeker
 	offset     int
@@ -18,6 +21,11 @@ type Osz2Reader struct {
 
 // NewOsz2Reader creates a new Osz2Reader
 func NewOsz2Reader(reader io.ReadSeeker, offset int, key []byte) (*Osz2Reader, error) {
+	return NewOsz2ReaderWithCipher(reader, offset, NewXXTEA(bytesToUint32Array(key)))
+}
+
+// NewOsz2ReaderWithCipher creates a new Osz2Reader sharing an existing XXTEA instance
+func NewOsz2ReaderWithCipher(reader io.ReadSeeker, offset int, xxtea *XXTEA) (*Osz2Reader, error) {
 	// Read encrypted length
 	encryptedLength := make([]byte, 4)
 	reader.Seek(int64(offset), io.SeekStart)
@@ -25,10 +33,6 @@ func NewOsz2Reader(reader io.ReadSeeker, offset int, key []byte) (*Osz2Reader, e
 		return nil, err
 	}
 
-	// Convert key to uint32 array
-	keyBuffer := bytesToUint32Array(key)
-	xxtea := NewXXTEA(keyBuffer)
-
 	// Decrypt the length
 	xxtea.Decrypt(encryptedLength, 0, 4)
 
@@ -45,6 +49,11 @@ func NewOsz2Reader(reader io.ReadSeeker, offset int, key []byte) (*Osz2Reader, e
 	}, nil
 }
 
+// Length returns the decrypted length of the file contents, excluding the prefix
+func (osz2 *Osz2Reader) Length() int {
+	return osz2.length
+}
+
 // Position returns the current position in the stream
 func (osz2 *Osz2Reader) Position() int {
 	return osz2.position - osz2.offset
@@ -90,7 +99,7 @@ func (osz2 *Osz2Reader) Read(buffer []byte) (int, error) {
 		}
 	}
 
-	firstBytes := int(math.Min(64, float64(osz2.length-seekablePosition)))
+	firstBytes := min(64, osz2.length-seekablePosition)
 
 	// Read data and decrypt
 	osz2.reader.Seek(int64(seekablePosition+osz2.offset), io.SeekStart)
@@ -99,11 +108,11 @@ func (osz2 *Osz2Reader) Read(buffer []byte) (int, error) {
 	}
 	osz2.decrypt(osz2.skipBuffer, 0, firstBytes)
 
-	copyLen := int(math.Min(float64(64-skipOffset), float64(count)))
+	copyLen := min(64-skipOffset, count)
 	copy(buffer[offset:], osz2.skipBuffer[skipOffset:skipOffset+copyLen])
 
 	if endLeftOver > 0 {
-		lastBytes := int(math.Min(64, float64(osz2.length-seekableEnd)))
+		lastBytes := min(64, osz2.length-seekableEnd)
 
 		// Read data and decrypt
 		osz2.reader.Seek(int64(seekableEnd+osz2.offset), io.SeekStart)
@@ -126,12 +135,12 @@ func (osz2 *Osz2Reader) seek(offset int, whence int) int {
 	switch whence {
 	case io.SeekStart:
 		if offset >= 0 {
-			osz2.position = int(math.Min(float64(offset), float64(osz2.length))) + osz2.offset
+			osz2.position = min(offset, osz2.length) + osz2.offset
 		}
 	case io.SeekCurrent:
 		if osz2.Position()+offset >= 0 {
 			newPos := osz2.position + offset - osz2.offset
-			osz2.position = int(math.Min(float64(newPos), float64(osz2.length))) + osz2.offset
+			osz2.position = min(newPos, osz2.length) + osz2.offset
 		}
 	case io.SeekEnd:
 		if osz2.length+offset >= 0 {
@@ -1,11 +1,51 @@
 package osz2
 
 import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/Lekuruu/osz2-go/dotnet"
 )
 
+// update records the snapshots in tests/snapshots instead of comparing against them
+var update = flag.Bool("update", false, "update the snapshots")
+
+// readTestFile reads a file of the tests directory
+func readTestFile(t testing.TB, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read test file: %v", err)
+	}
+	return data
+}
+
+// readTestPackage reads a package of the tests directory, returning
+// its data and the package parsed from it
+func readTestPackage(t testing.TB, path string, metadataOnly bool, options ...Option) ([]byte, *Package) {
+	t.Helper()
+	data := readTestFile(t, path)
+	pkg, err := NewPackage(bytes.NewReader(data), metadataOnly, options...)
+	if err != nil {
+		t.Fatalf("Failed to parse package: %v", err)
+	}
+	return data, pkg
+}
+
 // TestPackages tests parsing of all .osz2 files in the tests directory
 func TestPackages(t *testing.T) {
 	testFiles := []string{}
@@ -155,6 +195,192 @@ func TestMetadataOnly(t *testing.T) {
 	}
 }
 
+// TestExplicitKey tests opening a package with a key supplied by the caller
+func TestExplicitKey(t *testing.T) {
+	data := readTestFile(t, "tests/nekodex - welcome to christmas.osz2")
+	key := DeriveKey("peppy", "-1")
+
+	pkg, err := NewPackage(bytes.NewReader(data), false, WithKey(key))
+	if err != nil {
+		t.Fatalf("Failed to parse package with explicit key: %v", err)
+	}
+	if !bytes.Equal(pkg.Key(), key) {
+		t.Errorf("Package key is %x, expected %x", pkg.Key(), key)
+	}
+	if len(pkg.Files) != len(pkg.FileInfos) {
+		t.Errorf("Expected %d files, got %d", len(pkg.FileInfos), len(pkg.Files))
+	}
+
+	// The key function receives the metadata that was read
+	var creator string
+	keyFunc := func(metadata map[MetaType]string) ([]byte, error) {
+		creator = metadata[Creator]
+		return key, nil
+	}
+	if _, err := NewPackage(bytes.NewReader(data), true, WithKeyFunc(keyFunc)); err != nil {
+		t.Fatalf("Failed to parse package with key function: %v", err)
+	}
+	if creator != "peppy" {
+		t.Errorf("Key function received creator %q, expected %q", creator, "peppy")
+	}
+
+	// Errors of the key function and invalid keys are reported
+	failing := func(map[MetaType]string) ([]byte, error) {
+		return nil, errors.New("no key")
+	}
+	if _, err := NewPackage(bytes.NewReader(data), true, WithKeyFunc(failing)); err == nil {
+		t.Error("Expected error from failing key function, got nil")
+	}
+	if _, err := NewPackage(bytes.NewReader(data), true, WithKey([]byte("short"))); err == nil {
+		t.Error("Expected error for invalid key length, got nil")
+	}
+}
+
+// TestWrongKey tests that a wrong key is detected using the magic block
+func TestWrongKey(t *testing.T) {
+	data := readTestFile(t, "tests/nekodex - welcome to christmas.osz2")
+	wrongKey := DeriveKey("peppy", "1")
+
+	_, err := NewPackage(bytes.NewReader(data), false, WithKey(wrongKey))
+	if !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Expected ErrWrongKey, got %v", err)
+	}
+
+	// Metadata-only mode does not fail, but reports the key as invalid
+	pkg, err := NewPackage(bytes.NewReader(data), true, WithKey(wrongKey))
+	if err != nil {
+		t.Fatalf("Failed to parse metadata with wrong key: %v", err)
+	}
+	if pkg.KeyValid() {
+		t.Error("Expected wrong key to be reported as invalid")
+	}
+
+	pkg, err = NewPackage(bytes.NewReader(data), true)
+	if err != nil {
+		t.Fatalf("Failed to parse metadata: %v", err)
+	}
+	if !pkg.KeyValid() {
+		t.Error("Expected derived key to be reported as valid")
+	}
+}
+
+// TestHeader tests reading the version byte and IV of the header
+func TestHeader(t *testing.T) {
+	data, pkg := readTestPackage(t, "tests/Karoo13 - Tic Tac Toe.osz2", true)
+	if pkg.Version != FormatVersion {
+		t.Errorf("Got version %d, expected %d", pkg.Version, FormatVersion)
+	}
+	if !bytes.Equal(pkg.IV, data[4:20]) {
+		t.Errorf("Got IV %x, expected %x", pkg.IV, data[4:20])
+	}
+	if len(pkg.Warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", pkg.Warnings)
+	}
+
+	// Unknown versions are rejected unless explicitly allowed
+	modified := append([]byte{}, data...)
+	modified[3] = 7
+
+	_, err := NewPackage(bytes.NewReader(modified), true)
+	if !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("Expected ErrUnknownVersion, got %v", err)
+	}
+
+	pkg, err = NewPackage(bytes.NewReader(modified), false, WithUnknownVersions())
+	if err != nil {
+		t.Fatalf("Failed to parse package with unknown version: %v", err)
+	}
+	if pkg.Version != 7 {
+		t.Errorf("Got version %d, expected 7", pkg.Version)
+	}
+	if len(pkg.Warnings) != 1 || !errors.Is(pkg.Warnings[0], ErrUnknownVersion) {
+		t.Errorf("Expected an ErrUnknownVersion warning, got %v", pkg.Warnings)
+	}
+}
+
+// removeMetadata returns a copy of a package without the given metadata
+// entries, with the metadata hash updated to match
+func removeMetadata(t *testing.T, data []byte, remove ...MetaType) []byte {
+	t.Helper()
+	r := bytes.NewReader(data[68:])
+	reader := dotnet.NewReader(r)
+
+	count, err := reader.ReadInt32()
+	if err != nil {
+		t.Fatalf("Failed to read metadata count: %v", err)
+	}
+
+	var entries bytes.Buffer
+	writer := dotnet.NewWriter(&entries)
+	kept := 0
+
+	for i := int32(0); i < count; i++ {
+		metaType, err := reader.ReadInt16()
+		if err != nil {
+			t.Fatalf("Failed to read metadata type: %v", err)
+		}
+		value, err := reader.ReadString()
+		if err != nil {
+			t.Fatalf("Failed to read metadata value: %v", err)
+		}
+		if slices.Contains(remove, MetaType(metaType)) {
+			continue
+		}
+		writer.WriteInt16(metaType)
+		writer.WriteString(value)
+		kept++
+	}
+
+	var metadata bytes.Buffer
+	dotnet.NewWriter(&metadata).WriteInt32(int32(kept))
+	metadata.Write(entries.Bytes())
+
+	result := append([]byte{}, data[:68]...)
+	copy(result[20:36], computeOszHash(metadata.Bytes(), kept*3, 0xa7))
+	result = append(result, metadata.Bytes()...)
+	return append(result, data[len(data)-r.Len():]...)
+}
+
+// TestKeyCandidates tests recovering the key of a package without key metadata
+func TestKeyCandidates(t *testing.T) {
+	data := readTestFile(t, "tests/nekodex - welcome to christmas.osz2")
+	stripped := removeMetadata(t, data, Creator, BeatmapSetID)
+
+	if _, err := NewPackage(bytes.NewReader(stripped), false); err == nil {
+		t.Fatal("Expected error for package without key metadata, got nil")
+	}
+
+	candidates := []KeyCandidate{
+		{Creator: "someone", BeatmapSetID: "1"},
+		{Creator: "peppy", BeatmapSetID: "-1"},
+	}
+	pkg, err := NewPackage(bytes.NewReader(stripped), false, WithKeyCandidates(candidates...))
+	if err != nil {
+		t.Fatalf("Failed to recover key: %v", err)
+	}
+	if pkg.MatchedCandidate == nil || *pkg.MatchedCandidate != candidates[1] {
+		t.Errorf("Expected candidate %v to match, got %v", candidates[1], pkg.MatchedCandidate)
+	}
+	if len(pkg.Files) == 0 || len(pkg.Files) != len(pkg.FileInfos) {
+		t.Errorf("Expected %d files, got %d", len(pkg.FileInfos), len(pkg.Files))
+	}
+
+	// No matching candidate
+	_, err = NewPackage(bytes.NewReader(stripped), true, WithKeyCandidates(candidates[0]))
+	if err == nil {
+		t.Error("Expected error when no candidate matches, got nil")
+	}
+
+	// The metadata key is preferred when it is valid
+	pkg, err = NewPackage(bytes.NewReader(data), true, WithKeyCandidates(candidates...))
+	if err != nil {
+		t.Fatalf("Failed to parse package with candidates: %v", err)
+	}
+	if pkg.MatchedCandidate != nil {
+		t.Errorf("Expected no candidate to be used, got %v", pkg.MatchedCandidate)
+	}
+}
+
 // TestInvalidFile tests handling of invalid files
 func TestInvalidFile(t *testing.T) {
 	// Create a temporary invalid file
@@ -178,6 +404,67 @@ func TestInvalidFile(t *testing.T) {
 	t.Logf("Correctly rejected invalid file with error: %v", err)
 }
 
+// TestLargeLengthPrefix checks that packages claiming strings longer than the
+// package are rejected without allocating the claimed length
+func TestLargeLengthPrefix(t *testing.T) {
+	header := append([]byte{}, fileIdentifier[:]...)
+	header = append(header, FormatVersion)
+	header = append(header, make([]byte, IVSize+3*16)...)
+
+	// A length prefix followed by a single byte of the string
+	prefix := func(length int32) []byte {
+		var buf bytes.Buffer
+		dotnet.NewWriter(&buf).Write7BitEncodedInt(length)
+		return append(buf.Bytes(), 'a')
+	}
+
+	tests := map[string][]byte{
+		"metadata":          bytes.Join([][]byte{header, {1, 0, 0, 0, 0, 0}, prefix(0x7FFFFFFF)}, nil),
+		"metadata in limit": bytes.Join([][]byte{header, {1, 0, 0, 0, 0, 0}, prefix(8 << 20)}, nil),
+		"file name":         bytes.Join([][]byte{header, {0, 0, 0, 0, 1, 0, 0, 0}, prefix(8 << 20)}, nil),
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			read := map[string]func() error{
+				"full": func() error {
+					_, err := NewPackage(bytes.NewReader(data), false)
+					return err
+				},
+				"metadata only": func() error {
+					_, err := NewPackage(bytes.NewReader(data), true)
+					return err
+				},
+				"salvage": func() error {
+					pkg, err := NewPackage(bytes.NewReader(data), false, WithSalvage())
+					if err == nil {
+						err = pkg.Salvage.Err
+					}
+					return err
+				},
+				"inspect": func() error {
+					_, err := Inspect(bytes.NewReader(data))
+					return err
+				},
+			}
+
+			for mode, f := range read {
+				var before, after runtime.MemStats
+				runtime.ReadMemStats(&before)
+				err := f()
+				runtime.ReadMemStats(&after)
+
+				if err == nil {
+					t.Errorf("%s: expected an error", mode)
+				}
+				if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+					t.Errorf("%s: allocated %d bytes for a %d byte package", mode, allocated, len(data))
+				}
+			}
+		})
+	}
+}
+
 // BenchmarkParsePackage benchmarks package parsing
 func BenchmarkParsePackage(b *testing.B) {
 	testFile := "tests/nekodex - welcome to christmas.osz2"
@@ -230,3 +517,429 @@ func BenchmarkParseMetadataOnly(b *testing.B) {
 		file.Close()
 	}
 }
+
+// TestEntries tests that the file entries are kept in the order they are stored in
+func TestEntries(t *testing.T) {
+	_, pkg := readTestPackage(t, "tests/Karoo13 - Tic Tac Toe.osz2", false)
+
+	if len(pkg.Entries) != len(pkg.FileInfos) {
+		t.Fatalf("Expected %d entries, got %d", len(pkg.FileInfos), len(pkg.Entries))
+	}
+	for i, entry := range pkg.Entries {
+		if pkg.FileInfos[entry.FileName] != entry {
+			t.Errorf("Entry %d (%s) is not the file info of its name", i, entry.FileName)
+		}
+		if i > 0 && entry.Offset != pkg.Entries[i-1].Offset+pkg.Entries[i-1].Size {
+			t.Errorf("Entry %d (%s) does not follow the previous entry", i, entry.FileName)
+		}
+	}
+
+	// Duplicate the first file at the end of the package
+	first := pkg.Entries[0].FileName
+	layout := pkg.layout(pkg.key, WriteOptions{})
+	layout.files = append(layout.files, first)
+
+	var buf bytes.Buffer
+	if err := pkg.writeWithLayout(&buf, pkg.key, layout); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	duplicated, err := NewPackage(bytes.NewReader(buf.Bytes()), false)
+	if err != nil {
+		t.Fatalf("Failed to parse package with duplicate entries: %v", err)
+	}
+
+	if len(duplicated.Warnings) != 1 || !errors.Is(duplicated.Warnings[0], ErrDuplicateFile) {
+		t.Errorf("Expected a duplicate file warning, got %v", duplicated.Warnings)
+	}
+	if len(duplicated.Entries) != len(pkg.Entries)+1 {
+		t.Fatalf("Expected %d entries, got %d", len(pkg.Entries)+1, len(duplicated.Entries))
+	}
+	last := duplicated.Entries[len(duplicated.Entries)-1]
+	if duplicated.FileInfos[first] != last {
+		t.Errorf("Expected %s to map to its last entry", first)
+	}
+	if !bytes.Equal(duplicated.Files[first], pkg.Files[first]) {
+		t.Errorf("File %s has wrong content", first)
+	}
+
+	// Writing drops the shadowed entry, which moves the file to the end
+	buf.Reset()
+	if _, err := duplicated.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	written, err := NewPackage(bytes.NewReader(buf.Bytes()), false)
+	if err != nil {
+		t.Fatalf("Failed to parse written package: %v", err)
+	}
+	if len(written.Entries) != len(pkg.Entries) || written.Entries[len(written.Entries)-1].FileName != first {
+		t.Errorf("Unexpected entries after writing: %v", written.entryNames())
+	}
+}
+
+// TestTrailingData tests that data after the last file body does not
+// change the size of the last file and is written back unchanged
+func TestTrailingData(t *testing.T) {
+	_, pkg := readTestPackage(t, "tests/Karoo13 - Tic Tac Toe.osz2", false)
+	if pkg.TrailingData != nil {
+		t.Fatalf("Expected no trailing data, got %d bytes", len(pkg.TrailingData))
+	}
+
+	trailing := bytes.Repeat([]byte("video"), 1000)
+	pkg.TrailingData = trailing
+
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	written, err := NewPackage(bytes.NewReader(buf.Bytes()), false)
+	if err != nil {
+		t.Fatalf("Failed to parse package with trailing data: %v", err)
+	}
+
+	if len(written.Warnings) != 0 {
+		t.Errorf("Unexpected warnings: %v", written.Warnings)
+	}
+	if !bytes.Equal(written.TrailingData, trailing) {
+		t.Errorf("Trailing data differs (%d vs %d bytes)", len(written.TrailingData), len(trailing))
+	}
+	for i, entry := range written.Entries {
+		if entry.Size != pkg.Entries[i].Size {
+			t.Errorf("File %s has size %d, expected %d", entry.FileName, entry.Size, pkg.Entries[i].Size)
+		}
+		if !bytes.Equal(written.Files[entry.FileName], pkg.Files[entry.FileName]) {
+			t.Errorf("File %s has wrong content", entry.FileName)
+		}
+	}
+
+	var rewritten bytes.Buffer
+	if _, err := written.WriteTo(&rewritten); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	if !bytes.Equal(rewritten.Bytes(), buf.Bytes()) {
+		t.Error("Package with trailing data changed after a round trip")
+	}
+
+	// Without its last bytes, the last file body is incomplete
+	truncated := buf.Bytes()[:buf.Len()-len(trailing)-1]
+	if _, err := NewPackage(bytes.NewReader(truncated), false); err == nil {
+		t.Error("Expected an error for a truncated package")
+	}
+}
+
+// TestSizeMismatch tests a package where the length prefix of a
+// file disagrees with the size of the file in the file table
+func TestSizeMismatch(t *testing.T) {
+	data, pkg := readTestPackage(t, "tests/Karoo13 - Tic Tac Toe.osz2", false)
+
+	// Replace the length prefix of the first file with a shorter one
+	first := pkg.Entries[0]
+	var prefix bytes.Buffer
+	if _, err := NewOsz2Writer(&prefix, int(first.ContentLength)-1, pkg.key); err != nil {
+		t.Fatalf("Failed to encrypt length prefix: %v", err)
+	}
+	damaged := bytes.Clone(data)
+	copy(damaged[pkg.fileOffset+int64(first.Offset):], prefix.Bytes())
+
+	result, err := NewPackage(bytes.NewReader(damaged), false)
+	if err != nil {
+		t.Fatalf("Failed to parse damaged package: %v", err)
+	}
+
+	var mismatch *SizeMismatchError
+	if len(result.Warnings) != 1 || !errors.As(result.Warnings[0], &mismatch) {
+		t.Fatalf("Expected a size mismatch warning, got %v", result.Warnings)
+	}
+	if mismatch.FileName != first.FileName || mismatch.TableSize != first.Size || mismatch.ContentLength != first.ContentLength-1 {
+		t.Errorf("Unexpected mismatch: %+v", mismatch)
+	}
+	// The final partial block is encrypted as a whole, so only the full blocks decrypt correctly
+	content := result.Files[first.FileName]
+	blocks := len(content) &^ 63
+	if len(content) != int(first.ContentLength)-1 || !bytes.Equal(content[:blocks], pkg.Files[first.FileName][:blocks]) {
+		t.Errorf("Expected the first %d bytes of %s", first.ContentLength-1, first.FileName)
+	}
+
+	_, err = NewPackage(bytes.NewReader(damaged), false, WithStrictSizes())
+	if !errors.Is(err, ErrSizeMismatch) || !errors.As(err, &mismatch) {
+		t.Errorf("Expected a size mismatch error in strict mode, got %v", err)
+	}
+
+	// A prefix beyond the end of the package cannot be trusted
+	prefix.Reset()
+	NewOsz2Writer(&prefix, len(data), pkg.key)
+	copy(damaged[pkg.fileOffset+int64(first.Offset):], prefix.Bytes())
+	if _, err := NewPackage(bytes.NewReader(damaged), false); err == nil {
+		t.Error("Expected an error for a length prefix exceeding the package")
+	}
+}
+
+// syntheticFile is a file of a generated package
+type syntheticFile struct {
+	name    string
+	content []byte
+}
+
+// syntheticContent returns n pseudo-random bytes, determined by the seed
+func syntheticContent(n int, seed int64) []byte {
+	content := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(content)
+	return content
+}
+
+// generatePackage writes a package with the given metadata and files, in
+// the given order. The Creator and BeatmapSetID metadata default to fixed
+// values, .osu files get consecutive beatmap ids and the IV and timestamps
+// are fixed, so the same arguments always produce the same bytes.
+func generatePackage(t testing.TB, metadata map[MetaType]string, files ...syntheticFile) []byte {
+	t.Helper()
+
+	pkg := NewEmptyPackage()
+	pkg.IV = bytes.Repeat([]byte{0x5a}, IVSize)
+	pkg.Metadata[Creator] = "Creator"
+	pkg.Metadata[BeatmapSetID] = "1"
+	for metaType, value := range metadata {
+		pkg.Metadata[metaType] = value
+	}
+
+	timestamp := time.Date(2013, 5, 1, 12, 0, 0, 0, time.UTC)
+	beatmapID := int32(1)
+	for _, file := range files {
+		if err := pkg.AddFile(file.name, file.content); err != nil {
+			t.Fatalf("Failed to add %s: %v", file.name, err)
+		}
+		pkg.FileInfos[file.name].DateCreated = timestamp
+		pkg.FileInfos[file.name].DateModified = timestamp
+		if strings.HasSuffix(file.name, ".osu") {
+			pkg.SetBeatmapID(file.name, beatmapID)
+			beatmapID++
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestSyntheticPackages tests edge cases with generated packages
+func TestSyntheticPackages(t *testing.T) {
+	// Lengths around the 4 byte words, 8 byte XTEA blocks and 64 byte body blocks
+	var lengths []syntheticFile
+	for _, n := range []int{0, 1, 2, 3, 4, 5, 7, 8, 9, 15, 16, 17, 31, 32, 33, 63, 64, 65, 127, 128, 129, 4095, 4096, 4097} {
+		lengths = append(lengths, syntheticFile{fmt.Sprintf("length %d.bin", n), syntheticContent(n, int64(n))})
+	}
+
+	tests := []struct {
+		name     string
+		metadata map[MetaType]string
+		files    []syntheticFile
+	}{
+		{name: "no files"},
+		{
+			name:  "empty files",
+			files: []syntheticFile{{"empty.txt", nil}, {"a.osu", nil}, {"last.txt", nil}},
+		},
+		{
+			name: "unicode names",
+			files: []syntheticFile{
+				{"\u97f3\u697d.mp3", syntheticContent(100, 1)},
+				{"\u00c9l\u00e8ve - \u2606 (Cr\u00e9ateur) [\u96e3\u3057\u3044].osu", []byte("osu file format v14")},
+				{"\U0001f3b5.png", syntheticContent(10, 2)},
+			},
+		},
+		{
+			name: "nested directories",
+			files: []syntheticFile{
+				{"sb/a/b/c/sprite.png", syntheticContent(70, 3)},
+				{"sb\\windows\\sprite.png", syntheticContent(70, 4)},
+				{"./dot/../sprite.png", syntheticContent(70, 5)},
+			},
+		},
+		{name: "lengths", files: lengths},
+		{
+			name: "huge metadata",
+			metadata: map[MetaType]string{
+				Title:        strings.Repeat("Title ", 1000),
+				Tags:         strings.Repeat("tag ", 1<<18),
+				TitleUnicode: strings.Repeat("\u30bf\u30a4\u30c8\u30eb", 5000),
+				Unknown:      "unknown",
+			},
+			files: []syntheticFile{{"a.osu", []byte("osu file format v14")}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := generatePackage(t, test.metadata, test.files...)
+
+			pkg, err := NewPackage(bytes.NewReader(data), false, WithStrictSizes(), WithUTF8Policy(UTF8Error))
+			if err != nil {
+				t.Fatalf("Failed to parse package: %v", err)
+			}
+			if len(pkg.Warnings) > 0 {
+				t.Errorf("Unexpected warnings: %v", pkg.Warnings)
+			}
+			for metaType, value := range test.metadata {
+				if pkg.Metadata[metaType] != value {
+					t.Errorf("Metadata %v has wrong value", metaType)
+				}
+			}
+			if len(pkg.Entries) != len(test.files) {
+				t.Fatalf("Expected %d entries, got %d", len(test.files), len(pkg.Entries))
+			}
+
+			xxtea := NewXXTEA(bytesToUint32Array(pkg.Key()))
+			for i, file := range test.files {
+				if pkg.Entries[i].FileName != file.name {
+					t.Errorf("Entry %d: expected %q, got %q", i, file.name, pkg.Entries[i].FileName)
+				}
+				if !bytes.Equal(pkg.Files[file.name], file.content) {
+					t.Errorf("File %q has wrong content", file.name)
+				}
+
+				// Read the body in chunks that cross the block boundaries
+				for _, chunkSize := range []int{1, 3, 7, 64, 100} {
+					reader, err := NewOsz2ReaderWithCipher(bytes.NewReader(data), int(pkg.fileOffset)+int(pkg.Entries[i].Offset), xxtea)
+					if err != nil {
+						t.Fatalf("Failed to create reader for %q: %v", file.name, err)
+					}
+					var content []byte
+					chunk := make([]byte, chunkSize)
+					for {
+						n, err := reader.Read(chunk)
+						content = append(content, chunk[:n]...)
+						if err == io.EOF {
+							break
+						}
+						if err != nil {
+							t.Fatalf("Failed to read %q: %v", file.name, err)
+						}
+					}
+					if !bytes.Equal(content, file.content) {
+						t.Errorf("File %q read in chunks of %d has wrong content", file.name, chunkSize)
+					}
+				}
+			}
+
+			// Generated packages are written back identically
+			var buf bytes.Buffer
+			if _, err := pkg.WriteTo(&buf); err != nil {
+				t.Fatalf("Failed to write package: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), data) {
+				t.Error("Written package differs from the generated one")
+			}
+
+			inspection, err := Inspect(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Failed to inspect package: %v", err)
+			}
+			if !inspection.MetaDataHash.Match() || !inspection.FileInfoHash.Match() || !inspection.FullBodyHash.Match() {
+				t.Error("Expected all hashes to match")
+			}
+			if inspection.TrailingBytes != 0 {
+				t.Errorf("Expected no trailing bytes, got %d", inspection.TrailingBytes)
+			}
+		})
+	}
+}
+
+// snapshotPackage is the decrypted content of a fixture
+type snapshotPackage struct {
+	Metadata  map[string]string `json:"metadata"`
+	FileNames map[string]int32  `json:"file_names"`
+	Files     []snapshotFile    `json:"files"`
+}
+
+// snapshotFile is an entry of a fixture with the checksum of its decrypted content
+type snapshotFile struct {
+	FileName     string `json:"file_name"`
+	Offset       int32  `json:"offset"`
+	Size         int32  `json:"size"`
+	Hash         string `json:"hash"`
+	MD5          string `json:"md5"`
+	DateCreated  string `json:"date_created"`
+	DateModified string `json:"date_modified"`
+}
+
+// newSnapshotPackage collects the decrypted content of a package
+func newSnapshotPackage(pkg *Package) *snapshotPackage {
+	snapshot := &snapshotPackage{
+		Metadata:  make(map[string]string),
+		FileNames: pkg.FileNames,
+	}
+	for metaType, value := range pkg.Metadata {
+		snapshot.Metadata[metaType.String()] = value
+	}
+	for _, fileInfo := range pkg.Entries {
+		checksum := md5.Sum(pkg.Files[fileInfo.FileName])
+		snapshot.Files = append(snapshot.Files, snapshotFile{
+			FileName:     fileInfo.FileName,
+			Offset:       fileInfo.Offset,
+			Size:         fileInfo.Size,
+			Hash:         hex.EncodeToString(fileInfo.Hash),
+			MD5:          hex.EncodeToString(checksum[:]),
+			DateCreated:  fileInfo.DateCreated.UTC().Format(time.RFC3339Nano),
+			DateModified: fileInfo.DateModified.UTC().Format(time.RFC3339Nano),
+		})
+	}
+	return snapshot
+}
+
+// TestSnapshot compares the decrypted fixtures against the snapshots in
+// tests/snapshots, which were recorded with this package and not with the
+// reference implementation. It catches changes to the decrypted output, but
+// not errors that were already present when the snapshots were recorded.
+// Run "go test -run TestSnapshot -update" to record them after an intended change.
+func TestSnapshot(t *testing.T) {
+	fixtures, err := filepath.Glob("tests/*.osz2")
+	if err != nil {
+		t.Fatalf("Failed to list fixtures: %v", err)
+	}
+
+	for _, fixture := range fixtures {
+		t.Run(filepath.Base(fixture), func(t *testing.T) {
+			_, pkg := readTestPackage(t, fixture, false)
+
+			actual, err := json.MarshalIndent(newSnapshotPackage(pkg), "", "  ")
+			if err != nil {
+				t.Fatalf("Failed to encode package: %v", err)
+			}
+			actual = append(actual, '\n')
+
+			path := filepath.Join("tests", "snapshots", strings.TrimSuffix(filepath.Base(fixture), ".osz2")+".json")
+			expected, err := os.ReadFile(path)
+			if err != nil && !(*update && errors.Is(err, os.ErrNotExist)) {
+				t.Fatalf("Failed to read snapshot: %v", err)
+			}
+			if bytes.Equal(actual, expected) {
+				return
+			}
+
+			if *update {
+				if err := os.WriteFile(path, actual, 0644); err != nil {
+					t.Fatalf("Failed to update snapshot: %v", err)
+				}
+				t.Logf("Updated %s, review the changes before committing them", path)
+				return
+			}
+
+			// Report the first lines that differ
+			actualLines := strings.Split(string(actual), "\n")
+			expectedLines := strings.Split(string(expected), "\n")
+			for i := 0; i < max(len(actualLines), len(expectedLines)); i++ {
+				var actualLine, expectedLine string
+				if i < len(actualLines) {
+					actualLine = actualLines[i]
+				}
+				if i < len(expectedLines) {
+					expectedLine = expectedLines[i]
+				}
+				if actualLine != expectedLine {
+					t.Fatalf("%s differs at line %d:\n  expected: %s\n  actual:   %s", path, i+1, expectedLine, actualLine)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package osz2
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Osz2Writer provides encryption for osz2 file contents, the counterpart of Osz2Reader.
+// It writes the encrypted length prefix followed by the content, encrypted in blocks
+// of 64 bytes. Only a single block is buffered, so file bodies can be streamed.
+type Osz2Writer struct {
+	writer  io.Writer
+	length  int
+	written int
+	block   []byte
+	xxtea   *XXTEA
+}
+
+// NewOsz2Writer creates a new Osz2Writer for content of the given length
+// and writes the encrypted length prefix
+func NewOsz2Writer(writer io.Writer, length int, key []byte) (*Osz2Writer, error) {
+	return NewOsz2WriterWithCipher(writer, length, NewXXTEA(bytesToUint32Array(key)))
+}
+
+// NewOsz2WriterWithCipher creates a new Osz2Writer sharing an existing XXTEA instance
+func NewOsz2WriterWithCipher(writer io.Writer, length int, xxtea *XXTEA) (*Osz2Writer, error) {
+	if length < 0 || int64(length) > int64(^uint32(0)>>1) {
+		return nil, errors.New("invalid osz2 content length")
+	}
+
+	// Write encrypted length
+	encryptedLength := make([]byte, 4)
+	binary.LittleEndian.PutUint32(encryptedLength, uint32(length))
+	xxtea.Encrypt(encryptedLength, 0, 4)
+
+	if _, err := writer.Write(encryptedLength); err != nil {
+		return nil, err
+	}
+
+	return &Osz2Writer{
+		writer: writer,
+		length: length,
+		block:  make([]byte, 0, MaxBytes),
+		xxtea:  xxtea,
+	}, nil
+}
+
+// Write encrypts and writes data to the osz2 stream
+func (osz2 *Osz2Writer) Write(p []byte) (int, error) {
+	if osz2.written+len(p) > osz2.length {
+		return 0, errors.New("osz2 content exceeds the declared length")
+	}
+
+	n := 0
+	for len(p) > 0 {
+		// Fill up the current block
+		count := min(MaxBytes-len(osz2.block), len(p))
+		osz2.block = append(osz2.block, p[:count]...)
+		p = p[count:]
+		n += count
+		osz2.written += count
+
+		if len(osz2.block) == MaxBytes {
+			if err := osz2.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// Close writes the last partial block. It fails if less data than
+// the declared length was written. The underlying writer is not closed.
+func (osz2 *Osz2Writer) Close() error {
+	if osz2.written != osz2.length {
+		return io.ErrShortWrite
+	}
+	if len(osz2.block) == 0 {
+		return nil
+	}
+	return osz2.flush()
+}
+
+// flush encrypts and writes the buffered block
+func (osz2 *Osz2Writer) flush() error {
+	osz2.xxtea.Encrypt(osz2.block, 0, len(osz2.block))
+	_, err := osz2.writer.Write(osz2.block)
+	osz2.block = osz2.block[:0]
+	return err
+}
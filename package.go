@@ -2,13 +2,89 @@ package osz2
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/maphash"
 	"io"
-	"time"
+	"math"
+
+	"github.com/Lekuruu/osz2-go/dotnet"
+)
+
+// fileIdentifier is the magic number at the start of every osz2 package
+var fileIdentifier = [3]byte{0xEC, 0x48, 0x4F}
+
+// maxStringLength limits the strings read from a package. Metadata values and
+// file names are short, so longer length prefixes come from damaged packages.
+const maxStringLength = 16 << 20
+
+// newReader creates a reader for the sections of a package
+func newReader(r io.Reader) *dotnet.Reader {
+	reader := dotnet.NewReader(r)
+	reader.MaxStringLength = maxStringLength
+	return reader
+}
+
+// remaining returns the number of bytes after the current position of r
+func remaining(r io.Seeker) (int64, error) {
+	position, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := r.Seek(position, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return size - position, nil
+}
+
+var (
+	// ErrUnknownVersion is returned for packages with an unknown format version
+	ErrUnknownVersion = errors.New("unknown osz2 format version")
+
+	// ErrDuplicateFile is reported as a warning for file entries sharing a name
+	ErrDuplicateFile = errors.New("duplicate file name")
+
+	// ErrSizeMismatch is reported as a warning for files whose size in the
+	// file table disagrees with the length prefix of their body
+	ErrSizeMismatch = errors.New("file size mismatch")
+
+	// Errors for sections that do not match their hash in the header
+	ErrMetadataHashMismatch = errors.New("metadata hash mismatch")
+	ErrFileInfoHashMismatch = errors.New("fileInfo hash mismatch")
+	ErrBodyHashMismatch     = errors.New("body hash mismatch")
 )
 
+// SizeMismatchError reports a file whose size in the file table disagrees
+// with the length prefix of its body. It matches ErrSizeMismatch.
+type SizeMismatchError struct {
+	FileName string
+
+	// TableSize is the size from the offsets in the file table, including the prefix
+	TableSize int32
+
+	// ContentLength is the length stored in the prefix of the body
+	ContentLength int32
+}
+
+// Error returns a description of the mismatch
+func (e *SizeMismatchError) Error() string {
+	return fmt.Sprintf(
+		"%v: %s has %d bytes in the file table, but %d in its length prefix",
+		ErrSizeMismatch, e.FileName, e.TableSize, 4+e.ContentLength,
+	)
+}
+
+// Unwrap returns ErrSizeMismatch
+func (e *SizeMismatchError) Unwrap() error {
+	return ErrSizeMismatch
+}
+
 // Package represents an osz2 package
 type Package struct {
 	// Metadata contains .osu metadata (e.g Artist, Difficulty, etc..)
@@ -17,29 +93,102 @@ type Package struct {
 	// FileInfos contains .osu file info (e.g FileName, Hash, Size etc..)
 	FileInfos map[string]*FileInfo
 
+	// Entries contains the file infos in the order they are stored in, including
+	// entries with duplicate names. FileInfos maps a duplicate name to its last entry.
+	Entries []*FileInfo
+
 	// Files contains osz2 file contents
 	Files map[string][]byte
 
+	// TrailingData contains data stored after the last file body, such as
+	// an embedded video. It is written back unchanged after the files.
+	TrailingData []byte
+
 	// FileNames maps filename to beatmap id
 	FileNames map[string]int32
 
 	// FileIDs maps beatmap id to filename
 	FileIDs map[int32]string
 
+	// Version is the format version byte of the header
+	Version byte
+
+	// IV is the initialization vector stored in the header, it is
+	// not used by the ciphers but kept for faithful re-serialization
+	IV []byte
+
 	// Hashes
 	MetaDataHash []byte
 	FileInfoHash []byte
 	FullBodyHash []byte
 
+	// MatchedCandidate is the key candidate the package was decrypted
+	// with, or nil if no candidate was needed
+	MatchedCandidate *KeyCandidate
+
+	// Warnings contains problems that did not prevent reading the package
+	Warnings []error
+
+	// Salvage describes what was recovered from a package read
+	// with WithSalvage, and is nil otherwise
+	Salvage *SalvageReport
+
 	// Key for XTEA algorithm
 	key []byte
 
+	// Function used to determine the key, if set
+	keyFunc KeyFunc
+
+	// Candidates to recover the key from, if set
+	keyCandidates []KeyCandidate
+
+	// Creator and BeatmapSetID metadata the key was determined with, to
+	// detect whether it has to be derived again when writing
+	keyMetadata map[MetaType]string
+
+	// Encrypted magic block used to verify the key
+	magic []byte
+
+	// Offset of the magic block, where the encrypted sections begin
+	magicOffset int64
+
+	// Reader the package was read from, which the encrypted
+	// sections are copied from when writing
+	source io.ReadSeeker
+
+	// Digest of the file contents as they were read, to detect whether the
+	// encrypted sections of the source can be copied when writing
+	contentSeed   maphash.Seed
+	contentDigest uint64
+	digestValid   bool
+
+	// Offset of the first file body in the package
+	fileOffset int64
+
+	// On-disk order of metadata and file names
+	metadataOrder []MetaType
+	fileNameOrder []string
+
 	// Need decrypt only metadata?
 	metadataOnly bool
+
+	// Read packages with an unknown format version?
+	allowUnknownVersion bool
+
+	// Fail on files whose table size and length prefix disagree?
+	strictSizes bool
+
+	// How strings with invalid UTF-8 are read
+	utf8Policy UTF8Policy
+
+	// Original bytes of strings where invalid UTF-8 was replaced, by
+	// metadata type and by the beatmap id of the file name mapping
+	rawMetadata  map[MetaType]rawString
+	rawFileNames map[int32]rawString
 }
 
 // NewPackage creates a new osz2 package from a reader
-func NewPackage(r io.ReadSeeker, metadataOnly bool) (*Package, error) {
+func NewPackage(r io.ReadSeeker, metadataOnly bool, options ...Option) (*Package, error) {
 	p := &Package{
 		Metadata:     make(map[MetaType]string),
 		FileInfos:    make(map[string]*FileInfo),
@@ -49,6 +198,10 @@ func NewPackage(r io.ReadSeeker, metadataOnly bool) (*Package, error) {
 		metadataOnly: metadataOnly,
 	}
 
+	for _, option := range options {
+		option(p)
+	}
+
 	err := p.read(r)
 	if err != nil {
 		return nil, err
@@ -57,43 +210,81 @@ func NewPackage(r io.ReadSeeker, metadataOnly bool) (*Package, error) {
 	return p, nil
 }
 
+// NewEmptyPackage creates a new package without any metadata or files, to be
+// filled by the caller and written with WriteTo. The Creator and BeatmapSetID
+// metadata have to be set before writing, as the key is derived from them.
+func NewEmptyPackage() *Package {
+	iv := make([]byte, IVSize)
+	rand.Read(iv)
+
+	return &Package{
+		Metadata:  make(map[MetaType]string),
+		FileInfos: make(map[string]*FileInfo),
+		Files:     make(map[string][]byte),
+		FileNames: make(map[string]int32),
+		FileIDs:   make(map[int32]string),
+		Version:   FormatVersion,
+		IV:        iv,
+	}
+}
+
 // read reads the osz2 package data
 func (p *Package) read(r io.ReadSeeker) error {
+	reader := newReader(r)
+
 	// Read identifier (magic number)
-	identifier := make([]byte, 3)
-	if _, err := r.Read(identifier); err != nil {
+	identifier, err := reader.ReadBytes(len(fileIdentifier))
+	if err != nil {
 		return err
 	}
 
 	// Check if given .osz2 package is valid
-	if len(identifier) < 3 ||
-		identifier[0] != 0xEC ||
-		identifier[1] != 0x48 ||
-		identifier[2] != 0x4F {
+	if !bytes.Equal(identifier, fileIdentifier[:]) {
 		return errors.New("file is not valid .osz2 package")
 	}
 
-	// Skip unused version byte
-	r.Seek(1, io.SeekCurrent)
+	// Read format version
+	if p.Version, err = reader.ReadByte(); err != nil {
+		return err
+	}
 
-	// Skip unused IV
-	r.Seek(16, io.SeekCurrent)
+	if p.Version != FormatVersion {
+		err := fmt.Errorf("%w: %d", ErrUnknownVersion, p.Version)
+		if !p.allowUnknownVersion {
+			return err
+		}
+		p.Warnings = append(p.Warnings, err)
+	}
 
-	// Read hashes of .osu parts
-	p.MetaDataHash = make([]byte, 16)
-	p.FileInfoHash = make([]byte, 16)
-	p.FullBodyHash = make([]byte, 16)
+	// Read IV
+	if p.IV, err = reader.ReadBytes(IVSize); err != nil {
+		return err
+	}
 
-	if _, err := r.Read(p.MetaDataHash); err != nil {
+	// Read hashes of .osu parts
+	if p.MetaDataHash, err = reader.ReadBytes(16); err != nil {
 		return err
 	}
-	if _, err := r.Read(p.FileInfoHash); err != nil {
+	if p.FileInfoHash, err = reader.ReadBytes(16); err != nil {
 		return err
 	}
-	if _, err := r.Read(p.FullBodyHash); err != nil {
+	if p.FullBodyHash, err = reader.ReadBytes(16); err != nil {
 		return err
 	}
 
+	err = p.readSections(r, reader)
+	if err != nil && p.Salvage != nil {
+		// Keep everything that was read before the damaged section
+		p.Salvage.Err = err
+		return nil
+	}
+	return err
+}
+
+// readSections reads the sections following the header
+func (p *Package) readSections(r io.ReadSeeker, reader *dotnet.Reader) error {
+	var err error
+
 	// Read metadata block
 	if err := p.readMetadata(r); err != nil {
 		return err
@@ -104,87 +295,137 @@ func (p *Package) read(r io.ReadSeeker) error {
 		return err
 	}
 
-	// Generate seed using metadata
-	creator, ok_creator := p.Metadata[Creator]
-	beatmapSetID, ok_setID := p.Metadata[BeatmapSetID]
-
-	if !ok_creator || !ok_setID {
-		return errors.New("missing required metadata for key generation")
+	// Read magic encrypted bytes
+	p.magicOffset, _ = r.Seek(0, io.SeekCurrent)
+	if p.magic, err = reader.ReadBytes(64); err != nil {
+		return err
 	}
 
-	seed := creator + "yhxyfjo5" + beatmapSetID
-	p.key = ComputeHashBytesRaw([]byte(seed))
+	// Determine the key, usually generated from the metadata
+	key, err := p.resolveKey()
+	if err != nil {
+		return err
+	}
+	p.key = key
+	p.keyMetadata = keyMetadata(p.originalMetadata())
 
+	p.source = r
 	if !p.metadataOnly {
+		// Check the key before parsing the file info with it
+		if !p.KeyValid() {
+			return ErrWrongKey
+		}
+
 		return p.readFiles(r)
 	}
+	return nil
+}
+
+// loadFiles reads the file contents of a package read in metadata-only mode
+func (p *Package) loadFiles() error {
+	if p.source == nil {
+		return errors.New("package has no source to read files from")
+	}
+	if !p.KeyValid() {
+		return ErrWrongKey
+	}
+
+	if _, err := p.source.Seek(p.magicOffset+int64(len(p.magic)), io.SeekStart); err != nil {
+		return err
+	}
+	if err := p.readFiles(p.source); err != nil {
+		return err
+	}
 
+	p.metadataOnly = false
 	return nil
 }
 
 // readMetadata reads the metadata section
 func (p *Package) readMetadata(r io.ReadSeeker) error {
-	var count int32
-	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+	size, err := remaining(r)
+	if err != nil {
 		return err
 	}
 
-	// Buffer to store data for hash verification
+	// Keep the raw section for hash verification. The limit lets the
+	// reader check the length of strings against the size of the package.
 	var buf bytes.Buffer
-	buf.WriteByte(byte(count))
-	buf.WriteByte(byte(count >> 8))
-	buf.WriteByte(byte(count >> 16))
-	buf.WriteByte(byte(count >> 24))
+	reader := newReader(&io.LimitedReader{R: io.TeeReader(r, &buf), N: size})
+
+	count, err := reader.ReadInt32()
+	if err != nil {
+		return err
+	}
 
 	// Read metadata
 	for i := int32(0); i < count; i++ {
-		var metaType int16
-		if err := binary.Read(r, binary.LittleEndian, &metaType); err != nil {
+		metaType, err := reader.ReadInt16()
+		if err != nil {
 			return err
 		}
 
-		metaValue, err := readString(r)
+		metaValue, raw, err := p.readString(reader, "metadata "+MetaType(metaType).String())
 		if err != nil {
 			return err
 		}
+		if raw.raw != nil {
+			if p.rawMetadata == nil {
+				p.rawMetadata = make(map[MetaType]rawString)
+			}
+			p.rawMetadata[MetaType(metaType)] = raw
+		} else {
+			delete(p.rawMetadata, MetaType(metaType))
+		}
 
 		// Store metadata if it's a valid type
+		if _, exists := p.Metadata[MetaType(metaType)]; !exists {
+			p.metadataOrder = append(p.metadataOrder, MetaType(metaType))
+		}
 		p.Metadata[MetaType(metaType)] = metaValue
-
-		// Write to buffer for hash verification
-		buf.WriteByte(byte(metaType))
-		buf.WriteByte(byte(metaType >> 8))
-		writeStringToBuffer(&buf, metaValue)
 	}
 
 	// Verify metadata hash
 	hash := computeOszHash(buf.Bytes(), int(count)*3, 0xa7)
 	if !bytes.Equal(hash, p.MetaDataHash) {
-		return errors.New("metadata hash mismatch")
+		return p.check(ErrMetadataHashMismatch)
 	}
 
 	return nil
 }
 
 // readFileNames reads the filename to beatmap ID mapping
-func (p *Package) readFileNames(r io.ReadSeeker) error {
-	var mapsCount int32
-	if err := binary.Read(r, binary.LittleEndian, &mapsCount); err != nil {
+func (p *Package) readFileNames(r io.Reader) error {
+	reader := newReader(r)
+
+	mapsCount, err := reader.ReadInt32()
+	if err != nil {
 		return err
 	}
 
 	// Read all maps in .osz2 and add them to dictionaries
 	for i := int32(0); i < mapsCount; i++ {
-		fileName, err := readString(r)
+		fileName, raw, err := p.readString(reader, "file name mapping")
 		if err != nil {
 			return err
 		}
 
-		var beatmapID int32
-		if err := binary.Read(r, binary.LittleEndian, &beatmapID); err != nil {
+		beatmapID, err := reader.ReadInt32()
+		if err != nil {
 			return err
 		}
+		if raw.raw != nil {
+			if p.rawFileNames == nil {
+				p.rawFileNames = make(map[int32]rawString)
+			}
+			p.rawFileNames[beatmapID] = raw
+		} else {
+			delete(p.rawFileNames, beatmapID)
+		}
 
+		if _, exists := p.FileNames[fileName]; !exists {
+			p.fileNameOrder = append(p.fileNameOrder, fileName)
+		}
 		p.FileNames[fileName] = beatmapID
 		p.FileIDs[beatmapID] = fileName
 	}
@@ -194,22 +435,11 @@ func (p *Package) readFileNames(r io.ReadSeeker) error {
 
 // readFiles reads the actual file contents
 func (p *Package) readFiles(r io.ReadSeeker) error {
-	// Convert key to uint32 array for XTEA
-	key := bytesToUint32Array(p.key)
-
-	// Create XTEA for reading magic bytes
-	xtea := NewXTEA(key)
-
-	// Read and decrypt magic encrypted bytes
-	plain := make([]byte, 64)
-	if _, err := r.Read(plain); err != nil {
-		return err
-	}
-	xtea.Decrypt(plain, 0, 64)
+	reader := newReader(r)
 
 	// Read encrypted length
-	var length int32
-	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+	length, err := reader.ReadInt32()
+	if err != nil {
 		return err
 	}
 
@@ -218,19 +448,34 @@ func (p *Package) readFiles(r io.ReadSeeker) error {
 		length -= int32(p.FileInfoHash[i]) | (int32(p.FileInfoHash[i+1]) << 17)
 	}
 
+	// Check the length before allocating the file info
+	size, err := remaining(r)
+	if err != nil {
+		return err
+	}
+
+	if length < 0 {
+		return fmt.Errorf("invalid file info length %d", length)
+	}
+	if int64(length) > size {
+		err := fmt.Errorf("file info length %d exceeds the package", length)
+		if p.Salvage == nil {
+			return err
+		}
+		// Parse the entries that are present
+		p.Salvage.Err = err
+		length = int32(size)
+	}
+
 	// Read all .osu files info
-	fileInfo := make([]byte, length)
-	if _, err := r.Read(fileInfo); err != nil {
+	fileInfo, err := reader.ReadBytes(int(length))
+	if err != nil {
 		return err
 	}
 
 	// Get file start offset
 	fileOffset, _ := r.Seek(0, io.SeekCurrent)
-
-	// Get total file size
-	currentPos, _ := r.Seek(0, io.SeekCurrent)
-	totalSize, _ := r.Seek(0, io.SeekEnd)
-	r.Seek(currentPos, io.SeekStart)
+	p.fileOffset = fileOffset
 
 	// Create an XXTEA reader from the encrypted fileInfo bytes
 	// This matches the C# approach where XXTeaStream wraps the MemoryStream
@@ -238,81 +483,123 @@ func (p *Package) readFiles(r io.ReadSeeker) error {
 	keyArray := bytesToUint32Array(p.key)
 
 	// Create XXTEA reader to decrypt file info
-	fileInfoReader := NewXXTEAReader(bytes.NewReader(fileInfo), keyArray)
+	fileInfoReader := &io.LimitedReader{R: NewXXTEAReader(bytes.NewReader(fileInfo), keyArray), N: int64(len(fileInfo))}
 
 	// Parse the file info using the streaming XXTEA reader
-	err := p.parseFileInfo(
-		fileInfoReader, fileInfo,
-		int(fileOffset), int(totalSize),
-	)
+	if err := p.parseFileInfo(fileInfoReader, fileInfo); err != nil {
+		if p.Salvage == nil {
+			return err
+		}
+		if p.Salvage.Err == nil {
+			p.Salvage.Err = fmt.Errorf("failed to read file info: %w", err)
+		}
+	}
 
-	if err != nil {
+	// All file bodies are encrypted with the same key
+	xxtea := NewXXTEA(keyArray)
+
+	if p.Salvage != nil {
+		return p.salvageFiles(r, xxtea)
+	}
+
+	// Determine the sizes from the length prefixes of the bodies
+	if err := p.readSizes(r, xxtea); err != nil {
 		return err
 	}
 
 	// Read file contents
-	return p.readFileContents(r, int(fileOffset))
+	if err := p.readFileContents(r, int(fileOffset), xxtea); err != nil {
+		return err
+	}
+	if err := p.readTrailingData(r); err != nil {
+		return err
+	}
+
+	p.contentSeed = maphash.MakeSeed()
+	p.contentDigest = p.digest(orderedKeys(p.Files, p.entryNames()))
+	p.digestValid = true
+	return nil
+}
+
+// digest returns a digest of the given files and the trailing data
+func (p *Package) digest(files []string) uint64 {
+	var h maphash.Hash
+	h.SetSeed(p.contentSeed)
+	for _, fileName := range files {
+		binary.Write(&h, binary.LittleEndian, int64(len(p.Files[fileName])))
+		h.Write(p.Files[fileName])
+	}
+	h.Write(p.TrailingData)
+	return h.Sum64()
 }
 
 // parseFileInfo parses the decrypted file info section
-func (p *Package) parseFileInfo(r io.Reader, encryptedFileInfo []byte, fileOffset int, totalSize int) error {
-	var count int32
-	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+func (p *Package) parseFileInfo(r io.Reader, encryptedFileInfo []byte) error {
+	reader := newReader(r)
+
+	count, err := reader.ReadInt32()
+	if err != nil {
 		return err
 	}
 
 	// Verify file info hash
 	fileInfoHash := computeOszHash(encryptedFileInfo, int(count)*4, 0xd1)
 	if !bytes.Equal(fileInfoHash, p.FileInfoHash) {
-		return errors.New("fileInfo hash mismatch")
+		return p.check(ErrFileInfoHashMismatch)
 	}
 
-	var currentOffset int32
-	if err := binary.Read(r, binary.LittleEndian, &currentOffset); err != nil {
+	// The offset of each entry precedes it, so a package without files ends here
+	if count <= 0 {
+		return nil
+	}
+	currentOffset, err := reader.ReadInt32()
+	if err != nil {
 		return err
 	}
 
 	for i := int32(0); i < count; i++ {
-		fileName, err := readStringFromBuffer(r)
+		fileName, rawName, err := p.readString(reader, "file info")
 		if err != nil {
 			return err
 		}
 
-		fileHash := make([]byte, 16)
-		if _, err := r.Read(fileHash); err != nil {
+		fileHash, err := reader.ReadBytes(16)
+		if err != nil {
 			return err
 		}
 
-		var dateCreatedBinary, dateModifiedBinary int64
-		if err := binary.Read(r, binary.LittleEndian, &dateCreatedBinary); err != nil {
+		// Timestamps are stored in .NET DateTime.ToBinary() format, which encodes both the ticks and the Kind
+		dateCreated, dateCreatedKind, err := reader.ReadDateTime()
+		if err != nil {
 			return err
 		}
-		if err := binary.Read(r, binary.LittleEndian, &dateModifiedBinary); err != nil {
+		dateModified, dateModifiedKind, err := reader.ReadDateTime()
+		if err != nil {
 			return err
 		}
 
-		// Convert from .NET DateTime.ToBinary() format
-		// .NET DateTime ticks are 100-nanosecond intervals since January 1, 0001
-		// DateTime.ToBinary() encodes both the ticks and the Kind
-		dateCreated := convertFromDotNetBinary(dateCreatedBinary)
-		dateModified := convertFromDotNetBinary(dateModifiedBinary)
-
-		var nextOffset int32
+		// The table only stores offsets, so the size of the last
+		// file is set from its length prefix in readSizes
+		var nextOffset, fileLength int32
 		if i+1 < count {
-			if err := binary.Read(r, binary.LittleEndian, &nextOffset); err != nil {
+			if nextOffset, err = reader.ReadInt32(); err != nil {
 				return err
 			}
-		} else {
-			// For last file, calculate size differently - use total file size minus file offset
-			nextOffset = int32(totalSize - fileOffset)
+			fileLength = nextOffset - currentOffset
 		}
 
-		fileLength := nextOffset - currentOffset
-
-		p.FileInfos[fileName] = NewFileInfo(
+		if _, exists := p.FileInfos[fileName]; exists {
+			p.Warnings = append(p.Warnings, fmt.Errorf("%w: %s", ErrDuplicateFile, fileName))
+		}
+		fileInfo := NewFileInfo(
 			fileName, currentOffset, fileLength,
 			fileHash, dateCreated, dateModified,
 		)
+		fileInfo.DateCreatedKind = dateCreatedKind
+		fileInfo.DateModifiedKind = dateModifiedKind
+		fileInfo.rawName = rawName
+		p.FileInfos[fileName] = fileInfo
+		p.Entries = append(p.Entries, fileInfo)
 
 		// Move to next file offset
 		currentOffset = nextOffset
@@ -321,127 +608,104 @@ func (p *Package) parseFileInfo(r io.Reader, encryptedFileInfo []byte, fileOffse
 	return nil
 }
 
-// readFileContents reads the actual file contents
-func (p *Package) readFileContents(r io.ReadSeeker, fileOffset int) error {
-	for fileName, fileInfo := range p.FileInfos {
-		// Create Osz2Stream equivalent
-		osz2Reader, err := NewOsz2Reader(r, fileOffset+int(fileInfo.Offset), p.key)
+// readSizes reads the length prefix of every file body into its content length.
+// The size of the last file is taken from it, as other data like a video may
+// follow the last body. For all other files, the prefix has to agree with the
+// offsets in the table, or a SizeMismatchError is reported.
+func (p *Package) readSizes(r io.ReadSeeker, xxtea *XXTEA) error {
+	totalSize, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	for i, fileInfo := range p.Entries {
+		offset := p.fileOffset + int64(fileInfo.Offset)
+		osz2Reader, err := NewOsz2ReaderWithCipher(r, int(offset), xxtea)
 		if err != nil {
-			fmt.Printf("Failed to create reader for: %s\n", fileName)
-			continue
+			return fmt.Errorf("failed to read length of %s: %w", fileInfo.FileName, err)
 		}
 
-		// Read file content
-		content := make([]byte, fileInfo.Size-4) // -4 because of the encrypted length prefix
-		_, err = osz2Reader.Read(content)
-		if err != nil {
-			fmt.Printf("Failed to read: %s\n", fileName)
-			continue
+		// The prefix is authoritative, but it has to fit in the package
+		length := int64(osz2Reader.Length())
+		if length > math.MaxInt32-4 || offset+4+length > totalSize {
+			return fmt.Errorf("length prefix of %s exceeds the package (%d bytes)", fileInfo.FileName, length)
 		}
+		fileInfo.ContentLength = int32(length)
 
-		p.Files[fileName] = content
+		if i == len(p.Entries)-1 {
+			fileInfo.Size = 4 + fileInfo.ContentLength
+			continue
+		}
+		if fileInfo.Size != 4+fileInfo.ContentLength {
+			err := &SizeMismatchError{
+				FileName:      fileInfo.FileName,
+				TableSize:     fileInfo.Size,
+				ContentLength: fileInfo.ContentLength,
+			}
+			if p.strictSizes {
+				return err
+			}
+			p.Warnings = append(p.Warnings, err)
+		}
 	}
-
 	return nil
 }
 
-// readString reads a .NET style string (length-prefixed)
-func readString(r io.Reader) (string, error) {
-	// Read length (7-bit encoded)
-	length, err := read7BitEncodedInt(r)
-	if err != nil {
-		return "", err
-	}
+// readFileContents reads the actual file contents
+func (p *Package) readFileContents(r io.ReadSeeker, fileOffset int, xxtea *XXTEA) error {
+	for _, fileInfo := range p.Entries {
+		fileName := fileInfo.FileName
+		if p.FileInfos[fileName] != fileInfo {
+			// Shadowed by a later entry with the same name
+			continue
+		}
 
-	if length == 0 {
-		return "", nil
-	}
+		// Create Osz2Stream equivalent
+		osz2Reader, err := NewOsz2ReaderWithCipher(r, fileOffset+int(fileInfo.Offset), xxtea)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", fileName, err)
+		}
+
+		// Read file content
+		content := make([]byte, fileInfo.ContentLength)
+		if len(content) > 0 {
+			if _, err = osz2Reader.Read(content); err != nil {
+				return fmt.Errorf("failed to read %s: %w", fileName, err)
+			}
+		}
 
-	data := make([]byte, length)
-	if _, err := r.Read(data); err != nil {
-		return "", err
+		p.Files[fileName] = content
 	}
 
-	return string(data), nil
+	return nil
 }
 
-// readStringFromBuffer reads a string from a byte buffer
-func readStringFromBuffer(r io.Reader) (string, error) {
-	// Read length (7-bit encoded)
-	length, err := read7BitEncodedIntFromBuffer(r)
-	if err != nil {
-		return "", err
+// readTrailingData reads the data following the last file body
+func (p *Package) readTrailingData(r io.ReadSeeker) error {
+	end := p.fileOffset
+	if len(p.Entries) > 0 {
+		last := p.Entries[len(p.Entries)-1]
+		end += int64(last.Offset) + int64(last.Size)
 	}
 
-	if length == 0 {
-		return "", nil
-	}
-
-	data := make([]byte, length)
-	_, err = io.ReadFull(r, data)
+	totalSize, err := r.Seek(0, io.SeekEnd)
 	if err != nil {
-		return "", err
+		return err
 	}
-
-	return string(data), nil
-}
-
-// writeStringToBuffer writes a string to buffer in .NET format
-func writeStringToBuffer(buf *bytes.Buffer, s string) {
-	write7BitEncodedInt(buf, len(s))
-	buf.WriteString(s)
-}
-
-// read7BitEncodedInt reads a 7-bit encoded integer (.NET style)
-func read7BitEncodedInt(r io.Reader) (int, error) {
-	var result int
-	var shift uint
-
-	for {
-		b := make([]byte, 1)
-		if _, err := r.Read(b); err != nil {
-			return 0, err
-		}
-
-		result |= int(b[0]&0x7F) << shift
-		if b[0]&0x80 == 0 {
-			break
-		}
-		shift += 7
+	if totalSize < end {
+		return fmt.Errorf("package is truncated: file bodies end at %d, but the package has %d bytes", end, totalSize)
 	}
-
-	return result, nil
-}
-
-// read7BitEncodedIntFromBuffer reads a 7-bit encoded integer from buffer
-func read7BitEncodedIntFromBuffer(r io.Reader) (int, error) {
-	var result int
-	var shift uint
-	b := make([]byte, 1)
-
-	for {
-		_, err := r.Read(b)
-		if err != nil {
-			return 0, err
-		}
-
-		result |= int(b[0]&0x7F) << shift
-		if b[0]&0x80 == 0 {
-			break
-		}
-		shift += 7
+	if totalSize == end {
+		p.TrailingData = nil
+		return nil
 	}
 
-	return result, nil
-}
-
-// write7BitEncodedInt writes a 7-bit encoded integer
-func write7BitEncodedInt(buf *bytes.Buffer, value int) {
-	for value >= 0x80 {
-		buf.WriteByte(byte(value | 0x80))
-		value >>= 7
+	if _, err := r.Seek(end, io.SeekStart); err != nil {
+		return err
 	}
-	buf.WriteByte(byte(value))
+	p.TrailingData = make([]byte, totalSize-end)
+	_, err = io.ReadFull(r, p.TrailingData)
+	return err
 }
 
 // bytesToUint32Array converts byte array to uint32 array
@@ -452,55 +716,3 @@ func bytesToUint32Array(data []byte) []uint32 {
 	}
 	return result
 }
-
-// computeOszHash computes MD5 hash of .osz parts
-func computeOszHash(buffer []byte, pos int, swap byte) []byte {
-	// Make a copy to avoid modifying the original
-	buf := make([]byte, len(buffer))
-	copy(buf, buffer)
-
-	// Ensure pos is within bounds
-	if pos >= len(buf) {
-		// If position is out of bounds, just compute hash without swapping
-		hash := ComputeHashBytesRaw(buf)
-
-		// Swap bytes as in C# implementation
-		for i := 0; i < 8; i++ {
-			tmp := hash[i]
-			hash[i] = hash[i+8]
-			hash[i+8] = tmp
-		}
-
-		hash[5] ^= 0x2d
-		return hash
-	}
-
-	buf[pos] ^= swap
-	hash := ComputeHashBytesRaw(buf)
-	buf[pos] ^= swap // restore original
-
-	// Swap bytes as in C# implementation
-	for i := 0; i < 8; i++ {
-		tmp := hash[i]
-		hash[i] = hash[i+8]
-		hash[i+8] = tmp
-	}
-
-	hash[5] ^= 0x2d
-	return hash
-}
-
-// convertFromDotNetBinary converts a .NET DateTime.ToBinary() value to a Go time.Time
-func convertFromDotNetBinary(binary int64) time.Time {
-	// .NET DateTime ticks are 100-nanosecond intervals since January 1, 0001
-	// Unix epoch is January 1, 1970, which is 621,355,968,000,000,000 ticks after January 1, 0001
-	const dotNetToUnixEpochTicks = 621355968000000000
-
-	// Extract the ticks (lower 62 bits) and ignore the Kind flags (upper 2 bits)
-	ticks := binary & 0x3FFFFFFFFFFFFFFF
-
-	// Convert to Unix timestamp (nanoseconds)
-	unixNanos := (ticks - dotNetToUnixEpochTicks) * 100
-
-	return time.Unix(0, unixNanos).UTC()
-}
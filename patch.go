@@ -0,0 +1,54 @@
+package osz2
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/Lekuruu/osz2-go/bsdiff"
+)
+
+// CreatePatch returns a binary patch in the BSDIFF40 format that turns the
+// package oldData into newData, like the patches osu! used for beatmap updates.
+// newData is read with NewPackage and the given options first, so that no
+// patch to a damaged package is created.
+func CreatePatch(oldData, newData []byte, options ...Option) ([]byte, error) {
+	if _, err := verifyPackage(newData, options); err != nil {
+		return nil, fmt.Errorf("new package: %w", err)
+	}
+	return bsdiff.Diff(oldData, newData), nil
+}
+
+// ApplyPatch applies a patch created by CreatePatch to the package oldData and
+// returns the patched package, both encoded and read with the given options.
+// The metadata, file info and body hashes of the patched package have to
+// match, so a patch applied to the wrong package is rejected.
+func ApplyPatch(oldData, patch []byte, options ...Option) ([]byte, *Package, error) {
+	newData, err := bsdiff.Patch(oldData, patch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pkg, err := verifyPackage(newData, options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("patched package: %w", err)
+	}
+	return newData, pkg, nil
+}
+
+// verifyPackage reads a package and verifies all of its hashes. Packages read
+// in salvage mode record the failed checks in their report instead.
+func verifyPackage(data []byte, options []Option) (*Package, error) {
+	r := bytes.NewReader(data)
+	pkg, err := NewPackage(r, false, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Salvage mode already checks the body hash
+	if pkg.Salvage == nil {
+		if err := pkg.checkBodyHash(r, int64(len(data))); err != nil {
+			return nil, err
+		}
+	}
+	return pkg, nil
+}
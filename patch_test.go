@@ -0,0 +1,55 @@
+package osz2
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// TestPatch tests creating and applying patches between package revisions
+func TestPatch(t *testing.T) {
+	oldData, pkg := readTestPackage(t, "tests/Karoo13 - Tic Tac Toe.osz2", false)
+
+	pkg.Metadata[Title] = "Tic Tac Toe (updated)"
+	if err := pkg.AddFile("readme.txt", []byte("updated")); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	newData := buf.Bytes()
+
+	patch, err := CreatePatch(oldData, newData)
+	if err != nil {
+		t.Fatalf("Failed to create patch: %v", err)
+	}
+	if len(patch) > len(newData)/10 {
+		t.Errorf("Patch has %d bytes for a package of %d bytes", len(patch), len(newData))
+	}
+
+	patched, patchedPkg, err := ApplyPatch(oldData, patch)
+	if err != nil {
+		t.Fatalf("Failed to apply patch: %v", err)
+	}
+	if !bytes.Equal(patched, newData) {
+		t.Fatal("Patched package differs from the new package")
+	}
+	if patchedPkg.Metadata[Title] != "Tic Tac Toe (updated)" || string(patchedPkg.Files["readme.txt"]) != "updated" {
+		t.Error("Patched package does not contain the changes")
+	}
+
+	// A patch applied to a different package fails the hash checks
+	damaged := bytes.Clone(oldData)
+	damaged[len(damaged)-100] ^= 0xff
+	if _, _, err := ApplyPatch(damaged, patch); !errors.Is(err, ErrBodyHashMismatch) {
+		t.Errorf("Expected %v, got %v", ErrBodyHashMismatch, err)
+	}
+
+	// No patches are created to damaged packages
+	damaged = bytes.Clone(newData)
+	damaged[len(damaged)-100] ^= 0xff
+	if _, err := CreatePatch(oldData, damaged); !errors.Is(err, ErrBodyHashMismatch) {
+		t.Errorf("Expected %v, got %v", ErrBodyHashMismatch, err)
+	}
+}
@@ -0,0 +1,153 @@
+package osz2
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+)
+
+// SalvageReport describes what was recovered from a package read with WithSalvage.
+//
+// The per-file hashes in the file table cannot be verified, as the algorithm
+// osu! computes them with is unknown. A file counts as recovered if all of its
+// bytes are present; its length prefix is checked against the file table and
+// the body hash in the header is checked over all file bodies at once.
+type SalvageReport struct {
+	// Recovered contains the files that were read completely, in package
+	// order. Their content was not checked against the per-file hash in the
+	// file table, only against their length prefix and the body hash.
+	Recovered []string
+
+	// Truncated contains the files whose body is only partially present
+	Truncated []string
+
+	// Missing contains the files without a readable body
+	Missing []string
+
+	// Shadowed contains the names of entries hidden by a later entry with
+	// the same name. Their bodies are checked, but not read. A damaged body
+	// is recorded in FailedChecks, not in Truncated or Missing.
+	Shadowed []string
+
+	// FailedChecks contains the hash and consistency checks that failed
+	FailedChecks []error
+
+	// Err is the error that prevented reading the rest of the package, if any
+	Err error
+}
+
+// Complete reports whether every file was recovered and all checks passed
+func (r *SalvageReport) Complete() bool {
+	return r.Err == nil && len(r.FailedChecks) == 0 && len(r.Truncated) == 0 && len(r.Missing) == 0
+}
+
+// check handles a failed check: packages read in salvage mode
+// record it in the report and continue, others fail with err
+func (p *Package) check(err error) error {
+	if p.Salvage == nil {
+		return err
+	}
+	p.Salvage.FailedChecks = append(p.Salvage.FailedChecks, err)
+	return nil
+}
+
+// salvageFiles reads the contents of every file whose body is completely
+// present, instead of failing on the first damaged one
+func (p *Package) salvageFiles(r io.ReadSeeker, xxtea *XXTEA) error {
+	totalSize, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	report := p.Salvage
+	complete := true
+
+	for i, fileInfo := range p.Entries {
+		fileName := fileInfo.FileName
+		offset := p.fileOffset + int64(fileInfo.Offset)
+		last := i == len(p.Entries)-1
+
+		// Entries shadowed by a later entry with the same name are classified
+		// first, so that their name is not reported for the body of another entry
+		shadowed := p.FileInfos[fileName] != fileInfo
+		if shadowed {
+			report.Shadowed = append(report.Shadowed, fileName)
+		}
+		damaged := func(list *[]string, state string) {
+			if shadowed {
+				report.FailedChecks = append(report.FailedChecks, fmt.Errorf("body of shadowed entry %s is %s", fileName, state))
+			} else {
+				*list = append(*list, fileName)
+			}
+			complete = false
+		}
+
+		if fileInfo.Offset < 0 || offset+4 > totalSize {
+			damaged(&report.Missing, "missing")
+			continue
+		}
+		osz2Reader, err := NewOsz2ReaderWithCipher(r, int(offset), xxtea)
+		if err != nil {
+			damaged(&report.Missing, "missing")
+			continue
+		}
+
+		length := int64(osz2Reader.Length())
+		if length > math.MaxInt32-4 {
+			report.FailedChecks = append(report.FailedChecks, fmt.Errorf("invalid length prefix of %s", fileName))
+			damaged(&report.Missing, "missing")
+			continue
+		}
+		if last {
+			fileInfo.Size = 4 + int32(length)
+		} else if fileInfo.Size != 4+int32(length) {
+			report.FailedChecks = append(report.FailedChecks, &SizeMismatchError{
+				FileName:      fileName,
+				TableSize:     fileInfo.Size,
+				ContentLength: int32(length),
+			})
+		}
+		fileInfo.ContentLength = int32(length)
+
+		if offset+4+length > totalSize {
+			damaged(&report.Truncated, "truncated")
+			continue
+		}
+		if shadowed {
+			continue
+		}
+
+		content := make([]byte, length)
+		if length > 0 {
+			if _, err := osz2Reader.Read(content); err != nil {
+				damaged(&report.Missing, "missing")
+				continue
+			}
+		}
+		p.Files[fileName] = content
+		report.Recovered = append(report.Recovered, fileName)
+	}
+
+	if complete && report.Err == nil {
+		if err := p.readTrailingData(r); err != nil {
+			return err
+		}
+	}
+	return p.checkBodyHash(r, totalSize)
+}
+
+// checkBodyHash verifies the body hash over everything following the file info
+func (p *Package) checkBodyHash(r io.ReadSeeker, totalSize int64) error {
+	if _, err := r.Seek(p.fileOffset, io.SeekStart); err != nil {
+		return err
+	}
+
+	hasher := newOszHasher(int(totalSize-p.fileOffset)/2, 0x9f)
+	if _, err := io.Copy(hasher, r); err != nil {
+		return err
+	}
+	if !bytes.Equal(hasher.Sum(), p.FullBodyHash) {
+		return p.check(ErrBodyHashMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package osz2
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// TestSalvage tests recovering files from damaged packages
+func TestSalvage(t *testing.T) {
+	data, original := readTestPackage(t, "tests/Karoo13 - Tic Tac Toe.osz2", false)
+	last := original.Entries[len(original.Entries)-1].FileName
+
+	pkg, err := NewPackage(bytes.NewReader(data), false, WithSalvage())
+	if err != nil {
+		t.Fatalf("Failed to salvage intact package: %v", err)
+	}
+	if !pkg.Salvage.Complete() || len(pkg.Salvage.Recovered) != len(original.Entries) {
+		t.Errorf("Intact package was not recovered completely: %+v", pkg.Salvage)
+	}
+
+	// Cut off the end of the last file
+	truncated := data[:len(data)-100]
+	if _, err := NewPackage(bytes.NewReader(truncated), false); err == nil {
+		t.Error("Expected an error for a truncated package without salvage mode")
+	}
+	pkg, err = NewPackage(bytes.NewReader(truncated), false, WithSalvage())
+	if err != nil {
+		t.Fatalf("Failed to salvage truncated package: %v", err)
+	}
+	report := pkg.Salvage
+	if !slices.Equal(report.Truncated, []string{last}) || len(report.Recovered) != len(original.Entries)-1 {
+		t.Errorf("Expected only %s to be truncated, got %+v", last, report)
+	}
+	if len(report.FailedChecks) != 1 || !errors.Is(report.FailedChecks[0], ErrBodyHashMismatch) {
+		t.Errorf("Expected a body hash mismatch, got %v", report.FailedChecks)
+	}
+	for _, name := range report.Recovered {
+		if !bytes.Equal(pkg.Files[name], original.Files[name]) {
+			t.Errorf("Recovered file %s has wrong content", name)
+		}
+	}
+	if _, ok := pkg.Files[last]; ok {
+		t.Errorf("Truncated file %s was read", last)
+	}
+
+	// Cut off the package inside the file info
+	pkg, err = NewPackage(bytes.NewReader(data[:int(original.fileOffset)-10]), false, WithSalvage())
+	if err != nil {
+		t.Fatalf("Failed to salvage package without files: %v", err)
+	}
+	if pkg.Salvage.Err == nil || len(pkg.Salvage.Recovered) != 0 {
+		t.Errorf("Expected no files to be recovered, got %+v", pkg.Salvage)
+	}
+	if pkg.Metadata[Title] != original.Metadata[Title] {
+		t.Error("Metadata was not recovered")
+	}
+
+	// Change a character of the title without updating the metadata hash
+	damaged := bytes.Clone(data)
+	i := bytes.Index(damaged, []byte(original.Metadata[Title]))
+	damaged[i] ^= 0x20
+	if _, err := NewPackage(bytes.NewReader(damaged), false); !errors.Is(err, ErrMetadataHashMismatch) {
+		t.Errorf("Expected %v, got %v", ErrMetadataHashMismatch, err)
+	}
+	pkg, err = NewPackage(bytes.NewReader(damaged), false, WithSalvage())
+	if err != nil {
+		t.Fatalf("Failed to salvage package: %v", err)
+	}
+	if len(pkg.Salvage.FailedChecks) != 1 || !errors.Is(pkg.Salvage.FailedChecks[0], ErrMetadataHashMismatch) {
+		t.Errorf("Expected a metadata hash mismatch, got %v", pkg.Salvage.FailedChecks)
+	}
+	if len(pkg.Salvage.Recovered) != len(original.Entries) {
+		t.Errorf("Expected all files to be recovered, got %+v", pkg.Salvage)
+	}
+
+	// Damage the body of an entry that is shadowed by a later entry with the same name
+	first := original.Entries[0].FileName
+	layout := original.layout(original.key, WriteOptions{})
+	layout.files = append(layout.files, first)
+	var buf bytes.Buffer
+	if err := original.writeWithLayout(&buf, original.key, layout); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	duplicated, err := NewPackage(bytes.NewReader(buf.Bytes()), false)
+	if err != nil {
+		t.Fatalf("Failed to parse package with duplicate entries: %v", err)
+	}
+	damaged = buf.Bytes()
+	damaged[duplicated.fileOffset+3] ^= 0x40
+
+	pkg, err = NewPackage(bytes.NewReader(damaged), false, WithSalvage())
+	if err != nil {
+		t.Fatalf("Failed to salvage package: %v", err)
+	}
+	report = pkg.Salvage
+	if !slices.Equal(report.Shadowed, []string{first}) || len(report.Truncated) != 0 || len(report.Missing) != 0 {
+		t.Errorf("Expected only %s to be shadowed, got %+v", first, report)
+	}
+	if !slices.Contains(report.Recovered, first) || !bytes.Equal(pkg.Files[first], original.Files[first]) {
+		t.Errorf("Expected %s to be recovered from its last entry", first)
+	}
+	if report.Complete() {
+		t.Error("Expected the damaged shadowed entry to fail a check")
+	}
+
+	// Without a complete header there is nothing to salvage
+	if _, err := NewPackage(bytes.NewReader(data[:40]), false, WithSalvage()); err == nil {
+		t.Error("Expected an error for a package without header")
+	}
+}
@@ -1,26 +1,37 @@
 package osz2
 
-// SimpleCryptor implements the simple encryption used in osz2
+// SimpleCryptor implements the simple encryption used in osz2.
+// A SimpleCryptor is immutable after creation and safe for concurrent use.
 type SimpleCryptor struct {
-	key []uint32
+	byteKey [16]byte
 }
 
 // NewSimpleCryptor creates a new SimpleCryptor
 func NewSimpleCryptor(key []uint32) *SimpleCryptor {
-	return &SimpleCryptor{key: key}
+	sc := &SimpleCryptor{}
+	copy(sc.byteKey[:], uint32SliceToByteSlice(key))
+	return sc
+}
+
+// Encrypt encrypts count bytes of buffer in place, starting at start
+func (sc *SimpleCryptor) Encrypt(buffer []byte, start, count int) {
+	sc.EncryptBytes(buffer[start : start+count])
+}
+
+// Decrypt decrypts count bytes of buffer in place, starting at start
+func (sc *SimpleCryptor) Decrypt(buffer []byte, start, count int) {
+	sc.DecryptBytes(buffer[start : start+count])
 }
 
 // EncryptBytes encrypts bytes in place
 func (sc *SimpleCryptor) EncryptBytes(buf []byte) {
-	byteKey := uint32SliceToByteSlice(sc.key)
 	var prevEncrypted byte = 0
 
 	for i := 0; i < len(buf); i++ {
-		// Handle modulo properly for potentially negative values
-		sum := int(buf[i]) + int(byteKey[i%16]>>2)
-		buf[i] = byte((sum%256 + 256) % 256)
+		// Byte arithmetic wraps around, which is the modulo 256 of the original
+		buf[i] += sc.byteKey[i%16] >> 2
 
-		buf[i] ^= rotateLeft(byteKey[15-i%16], byte((int(prevEncrypted)+len(buf)-i)%7))
+		buf[i] ^= rotateLeft(sc.byteKey[15-i%16], byte((int(prevEncrypted)+len(buf)-i)%7))
 		buf[i] = rotateRight(buf[i], byte((^uint32(prevEncrypted))%7))
 
 		prevEncrypted = buf[i]
@@ -29,17 +40,13 @@ func (sc *SimpleCryptor) EncryptBytes(buf []byte) {
 
 // DecryptBytes decrypts bytes in place
 func (sc *SimpleCryptor) DecryptBytes(buf []byte) {
-	byteKey := uint32SliceToByteSlice(sc.key)
 	var prevEncrypted byte = 0
 
 	for i := 0; i < len(buf); i++ {
 		tmpE := buf[i]
 		buf[i] = rotateLeft(buf[i], byte((^uint32(prevEncrypted))%7))
-		buf[i] ^= rotateLeft(byteKey[15-i%16], byte((int(prevEncrypted)+len(buf)-i)%7))
-
-		// Handle negative results properly - convert to uint before modulo
-		diff := int(buf[i]) - int(byteKey[i%16]>>2)
-		buf[i] = byte((diff%256 + 256) % 256)
+		buf[i] ^= rotateLeft(sc.byteKey[15-i%16], byte((int(prevEncrypted)+len(buf)-i)%7))
+		buf[i] -= sc.byteKey[i%16] >> 2
 
 		prevEncrypted = tmpE
 	}
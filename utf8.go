@@ -0,0 +1,144 @@
+package osz2
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Lekuruu/osz2-go/dotnet"
+)
+
+// ErrInvalidUTF8 is reported for strings in a package that are not valid UTF-8
+var ErrInvalidUTF8 = errors.New("invalid UTF-8 string")
+
+// UTF8Policy determines how strings with invalid UTF-8 are read
+type UTF8Policy int
+
+const (
+	// UTF8Keep keeps the invalid bytes in the string as they were read
+	UTF8Keep UTF8Policy = iota
+	// UTF8Replace replaces every invalid byte with U+FFFD. The original
+	// bytes are available through Package.RawMetadata and Package.RawFileName,
+	// and are written back for the strings that were not changed.
+	UTF8Replace
+	// UTF8Error fails to read the package with ErrInvalidUTF8
+	UTF8Error
+)
+
+// rawString holds the bytes a string was read from, if invalid UTF-8 in
+// them was replaced, and the string they were replaced with
+type rawString struct {
+	value string
+	raw   []byte
+}
+
+// original returns the bytes s was read from, as long as s is unchanged
+func (r rawString) original(s string) (string, bool) {
+	if r.raw == nil || r.value != s {
+		return s, false
+	}
+	return string(r.raw), true
+}
+
+// RawMetadata returns the bytes a metadata value was read from, if invalid
+// UTF-8 in them was replaced with U+FFFD because of UTF8Replace and the
+// value has not been changed since
+func (p *Package) RawMetadata(metaType MetaType) ([]byte, bool) {
+	raw, ok := p.rawMetadata[metaType].original(p.Metadata[metaType])
+	if !ok {
+		return nil, false
+	}
+	return []byte(raw), true
+}
+
+// RawFileName returns the bytes a file name was read from, if invalid UTF-8
+// in them was replaced with U+FFFD because of UTF8Replace. The file info is
+// checked before the beatmap id mapping, which stores the name separately.
+func (p *Package) RawFileName(fileName string) ([]byte, bool) {
+	if fileInfo, ok := p.FileInfos[fileName]; ok {
+		if raw, ok := fileInfo.rawName.original(fileName); ok {
+			return []byte(raw), true
+		}
+	}
+	if raw, ok := p.mappingName(fileName); ok {
+		return []byte(raw), true
+	}
+	return nil, false
+}
+
+// readString reads a string from the package, applying the UTF-8 policy.
+// Strings with invalid UTF-8 are recorded in Warnings, unless the policy is UTF8Error.
+// If the invalid bytes were replaced, the string is returned with the bytes it was read from.
+func (p *Package) readString(reader *dotnet.Reader, section string) (string, rawString, error) {
+	raw, err := reader.ReadStringBytes()
+	if err != nil {
+		return "", rawString{}, err
+	}
+	if utf8.Valid(raw) {
+		return string(raw), rawString{}, nil
+	}
+
+	err = fmt.Errorf("%w in %s: %q", ErrInvalidUTF8, section, raw)
+	if p.utf8Policy == UTF8Error {
+		return "", rawString{}, err
+	}
+	p.Warnings = append(p.Warnings, err)
+
+	if p.utf8Policy != UTF8Replace {
+		return string(raw), rawString{}, nil
+	}
+
+	s := replaceInvalidUTF8(raw)
+	return s, rawString{value: s, raw: raw}, nil
+}
+
+// metadataValue returns the metadata value to write, with the original bytes
+// of a value whose invalid UTF-8 was replaced
+func (p *Package) metadataValue(metaType MetaType) string {
+	value, _ := p.rawMetadata[metaType].original(p.Metadata[metaType])
+	return value
+}
+
+// mappingName returns the file name to write in the beatmap id mapping, with
+// the original bytes of a name whose invalid UTF-8 was replaced. The mapping
+// is located by its beatmap id, as the replaced names may be ambiguous.
+func (p *Package) mappingName(fileName string) (string, bool) {
+	beatmapID, ok := p.FileNames[fileName]
+	if !ok {
+		return fileName, false
+	}
+	return p.rawFileNames[beatmapID].original(fileName)
+}
+
+// originalMetadata returns the metadata as it was read, before invalid UTF-8
+// was replaced, which the key has to be derived from
+func (p *Package) originalMetadata() map[MetaType]string {
+	if len(p.rawMetadata) == 0 {
+		return p.Metadata
+	}
+
+	metadata := make(map[MetaType]string, len(p.Metadata))
+	for metaType := range p.Metadata {
+		metadata[metaType] = p.metadataValue(metaType)
+	}
+	return metadata
+}
+
+// replaceInvalidUTF8 replaces every invalid byte in data with U+FFFD
+func replaceInvalidUTF8(data []byte) string {
+	var builder strings.Builder
+	builder.Grow(len(data))
+
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			builder.WriteRune(utf8.RuneError)
+		} else {
+			builder.Write(data[:size])
+		}
+		data = data[size:]
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,166 @@
+package osz2
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// TestInvalidUTF8 tests reading strings with invalid UTF-8 with every policy
+func TestInvalidUTF8(t *testing.T) {
+	pkg := NewEmptyPackage()
+	pkg.Metadata[Creator] = "peppy\xff"
+	pkg.Metadata[BeatmapSetID] = "1"
+	pkg.Metadata[Title] = "Tic\xfeTac"
+	if err := pkg.AddFile("map\xc3.osu", []byte("osu file format v14")); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	pkg.SetBeatmapID("map\xc3.osu", 1)
+
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	data := buf.Bytes()
+
+	// Keep the invalid bytes
+	kept, err := NewPackage(bytes.NewReader(data), false)
+	if err != nil {
+		t.Fatalf("Failed to parse package: %v", err)
+	}
+	if kept.Metadata[Title] != "Tic\xfeTac" || kept.Files["map\xc3.osu"] == nil {
+		t.Error("Invalid strings were not kept")
+	}
+	// Creator and Title in the metadata, the mapping and the file info
+	if len(kept.Warnings) != 4 || !errors.Is(kept.Warnings[0], ErrInvalidUTF8) {
+		t.Errorf("Expected 4 invalid UTF-8 warnings, got %v", kept.Warnings)
+	}
+
+	// Replace the invalid bytes, keeping the originals for writing
+	replaced, err := NewPackage(bytes.NewReader(data), false, WithUTF8Policy(UTF8Replace))
+	if err != nil {
+		t.Fatalf("Failed to parse package: %v", err)
+	}
+	if replaced.Metadata[Title] != "Tic�Tac" || replaced.Metadata[Creator] != "peppy�" {
+		t.Errorf("Unexpected metadata: %q, %q", replaced.Metadata[Title], replaced.Metadata[Creator])
+	}
+	if _, ok := replaced.Files["map�.osu"]; !ok {
+		t.Error("Invalid file name was not replaced")
+	}
+	if beatmapID, ok := replaced.BeatmapID("map�.osu"); !ok || beatmapID != 1 {
+		t.Error("Invalid file name mapping was not replaced")
+	}
+	if raw, ok := replaced.RawMetadata(Title); !ok || string(raw) != "Tic\xfeTac" {
+		t.Errorf("RawMetadata = %q, %v", raw, ok)
+	}
+	if _, ok := replaced.RawMetadata(BeatmapSetID); ok {
+		t.Error("RawMetadata returned a valid string")
+	}
+	if raw, ok := replaced.RawFileName("map�.osu"); !ok || string(raw) != "map\xc3.osu" {
+		t.Errorf("RawFileName = %q, %v", raw, ok)
+	}
+
+	var rewritten bytes.Buffer
+	if _, err := replaced.WriteTo(&rewritten); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	if !bytes.Equal(rewritten.Bytes(), data) {
+		t.Error("Package with replaced strings changed after a round trip")
+	}
+
+	// A key function receives the metadata as it was read
+	keyFunc := WithKeyFunc(DeriveKeyFromMetadata)
+	if _, err := NewPackage(bytes.NewReader(data), false, WithUTF8Policy(UTF8Replace), keyFunc); err != nil {
+		t.Errorf("Failed to parse package with key function: %v", err)
+	}
+
+	// Fail on invalid bytes
+	if _, err := NewPackage(bytes.NewReader(data), false, WithUTF8Policy(UTF8Error)); !errors.Is(err, ErrInvalidUTF8) {
+		t.Errorf("Expected %v, got %v", ErrInvalidUTF8, err)
+	}
+}
+
+// TestInvalidUTF8Locations checks that the original bytes of replaced strings
+// stay with the value they were read for, even if the replaced strings are equal
+func TestInvalidUTF8Locations(t *testing.T) {
+	pkg := NewEmptyPackage()
+	pkg.Metadata[Creator] = "peppy"
+	pkg.Metadata[BeatmapSetID] = "1"
+	pkg.Metadata[Title] = "a\xff"
+	pkg.Metadata[Source] = "a\xfe"
+	pkg.Metadata[Tags] = "map\xfe.osu"
+	if err := pkg.AddFile("map\xc3.osu", []byte("osu file format v14")); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	pkg.SetBeatmapID("map\xc3.osu", 1)
+
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	data := buf.Bytes()
+
+	replaced, err := NewPackage(bytes.NewReader(data), false, WithUTF8Policy(UTF8Replace))
+	if err != nil {
+		t.Fatalf("Failed to parse package: %v", err)
+	}
+	if replaced.Metadata[Title] != replaced.Metadata[Source] {
+		t.Fatalf("Expected equal replaced values, got %q and %q", replaced.Metadata[Title], replaced.Metadata[Source])
+	}
+
+	raws := map[MetaType]string{Title: "a\xff", Source: "a\xfe", Tags: "map\xfe.osu"}
+	for metaType, expected := range raws {
+		if raw, ok := replaced.RawMetadata(metaType); !ok || string(raw) != expected {
+			t.Errorf("RawMetadata(%s) = %q, %v, expected %q", metaType, raw, ok, expected)
+		}
+	}
+	if raw, ok := replaced.RawFileName("map�.osu"); !ok || string(raw) != "map\xc3.osu" {
+		t.Errorf("RawFileName = %q, %v", raw, ok)
+	}
+
+	var rewritten bytes.Buffer
+	if _, err := replaced.WriteTo(&rewritten); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	if !bytes.Equal(rewritten.Bytes(), data) {
+		t.Error("Package with colliding replaced strings changed after a round trip")
+	}
+
+	// Values set by the user are written as they are, even if they equal
+	// a replaced string of another field
+	replaced.Metadata[Artist] = "a\uFFFD"
+	replaced.Metadata[Source] = "b"
+	if _, ok := replaced.RawMetadata(Artist); ok {
+		t.Error("RawMetadata returned bytes for a new value")
+	}
+	if _, ok := replaced.RawMetadata(Source); ok {
+		t.Error("RawMetadata returned bytes for a changed value")
+	}
+	if err := replaced.AddFile("new\uFFFD.osu", []byte("osu file format v14")); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	replaced.SetBeatmapID("new\uFFFD.osu", 2)
+
+	rewritten.Reset()
+	if _, err := replaced.WriteTo(&rewritten); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	kept, err := NewPackage(bytes.NewReader(rewritten.Bytes()), false)
+	if err != nil {
+		t.Fatalf("Failed to parse package: %v", err)
+	}
+	expected := map[MetaType]string{Title: "a\xff", Source: "b", Artist: "a\uFFFD", Tags: "map\xfe.osu"}
+	for metaType, value := range expected {
+		if kept.Metadata[metaType] != value {
+			t.Errorf("%s = %q, expected %q", metaType, kept.Metadata[metaType], value)
+		}
+	}
+	for _, fileName := range []string{"map\xc3.osu", "new\uFFFD.osu"} {
+		if _, ok := kept.Files[fileName]; !ok {
+			t.Errorf("Missing file %q", fileName)
+		}
+		if _, ok := kept.FileNames[fileName]; !ok {
+			t.Errorf("Missing beatmap id mapping for %q", fileName)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package osz2
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Lekuruu/osz2-go/dotnet"
+)
+
+// WriteOptions configures how a package is written
+type WriteOptions struct {
+	// Deterministic makes the output depend only on the package contents:
+	// the IV is derived from the key, metadata, file names and files are
+	// written in sorted order and all timestamps are set to Timestamp.
+	// Packing the same inputs then always yields the same bytes.
+	Deterministic bool
+
+	// Timestamp replaces all file timestamps in deterministic mode.
+	// If it is zero, SOURCE_DATE_EPOCH is used, or the Unix epoch if unset.
+	Timestamp time.Time
+}
+
+// WriteTo writes the package in the osz2 format. Writing a package that was
+// read without modifications produces output identical to the original file.
+//
+// The package is encrypted with the key it was read with. If its Creator or
+// BeatmapSetID metadata changed, or for a new package, the key is derived
+// from them instead, unless they are missing.
+// For packages read in metadata-only mode, only the plain sections are
+// re-encoded and the encrypted sections are copied from the reader passed
+// to NewPackage, which has to remain usable. If the metadata change affects
+// the key, the files are read from that reader and encrypted again.
+//
+// Packages read with their files are encrypted again only if the files or
+// the key changed. Otherwise the encrypted file bodies are copied from the
+// reader passed to NewPackage, as long as it still starts with the same
+// encrypted file info. Writing fails if reading the bodies from it fails.
+func (p *Package) WriteTo(w io.Writer) (int64, error) {
+	return p.Write(w, WriteOptions{})
+}
+
+// Write writes the package in the osz2 format with the given options
+func (p *Package) Write(w io.Writer, options WriteOptions) (int64, error) {
+	writer := &countingWriter{writer: w}
+	err := p.write(writer, options)
+	return writer.count, err
+}
+
+// writeLayout contains the header values and the order of entries a package is written with
+type writeLayout struct {
+	iv        []byte
+	metadata  []MetaType
+	fileNames []string
+	files     []string
+
+	// timestamp replaces all file timestamps, if set
+	timestamp *time.Time
+}
+
+// layout determines the header values and the order of entries
+func (p *Package) layout(key []byte, options WriteOptions) writeLayout {
+	if !options.Deterministic {
+		return writeLayout{
+			iv:        p.IV,
+			metadata:  orderedKeys(p.Metadata, p.metadataOrder),
+			fileNames: orderedKeys(p.FileNames, p.fileNameOrder),
+			files:     orderedKeys(p.Files, p.entryNames()),
+		}
+	}
+
+	timestamp := options.Timestamp
+	if timestamp.IsZero() {
+		timestamp, _ = SourceDateEpoch()
+	}
+
+	return writeLayout{
+		iv:        ComputeHashBytesRaw(append([]byte("osz2-iv"), key...)),
+		metadata:  orderedKeys(p.Metadata, nil),
+		fileNames: orderedKeys(p.FileNames, nil),
+		files:     orderedKeys(p.Files, nil),
+		timestamp: &timestamp,
+	}
+}
+
+// write writes the package to w
+func (p *Package) write(w io.Writer, options WriteOptions) error {
+	key := p.writeKey()
+	if len(key) != KeySize {
+		return errors.New("invalid package key length")
+	}
+	if len(p.IV) != IVSize && !options.Deterministic {
+		return errors.New("invalid package IV length")
+	}
+
+	if p.metadataOnly {
+		if bytes.Equal(key, p.key) && !options.Deterministic {
+			return p.writeCopy(w)
+		}
+
+		// Changing the key or the file order requires encrypting the files again
+		if err := p.loadFiles(); err != nil {
+			return err
+		}
+	}
+
+	return p.writeWithLayout(w, key, p.layout(key, options))
+}
+
+// writeWithLayout encrypts and writes the package with the given layout
+func (p *Package) writeWithLayout(w io.Writer, key []byte, layout writeLayout) error {
+	xxtea := NewXXTEA(bytesToUint32Array(key))
+
+	metadata := p.encodeMetadata(layout.metadata)
+	metadataHash := computeOszHash(metadata, len(layout.metadata)*3, 0xa7)
+
+	fileInfo, err := p.encodeFileInfo(layout, xxtea)
+	if err != nil {
+		return err
+	}
+	fileInfoHash := computeOszHash(fileInfo, len(layout.files)*4, 0xd1)
+
+	// Magic encrypted bytes
+	var section bytes.Buffer
+	magic := magicPlaintext
+	NewXTEA(bytesToUint32Array(key)).Encrypt(magic[:], 0, len(magic))
+	section.Write(magic[:])
+
+	// Encode length by file info hash
+	length := int32(len(fileInfo))
+	for i := 0; i < 16; i += 2 {
+		length += int32(fileInfoHash[i]) | (int32(fileInfoHash[i+1]) << 17)
+	}
+	dotnet.NewWriter(&section).WriteInt32(length)
+	section.Write(fileInfo)
+
+	// The body hash has to be known before the body is written. The body is
+	// copied from the source with its hash if it is unchanged, otherwise the
+	// file contents are encrypted twice instead of buffered.
+	copyBody := p.bodyCopyable(key, layout, section.Bytes())
+	bodyHash := p.FullBodyHash
+	if !copyBody {
+		bodyLength := 0
+		for _, fileName := range layout.files {
+			bodyLength += 4 + len(p.Files[fileName])
+		}
+		bodyLength += len(p.TrailingData)
+		bodyHasher := newOszHasher(bodyLength/2, 0x9f)
+		if err := p.writeFileContents(bodyHasher, layout.files, xxtea); err != nil {
+			return err
+		}
+		bodyHasher.Write(p.TrailingData)
+		bodyHash = bodyHasher.Sum()
+	}
+
+	var header bytes.Buffer
+	p.encodeHeader(&header, layout, metadataHash, fileInfoHash, bodyHash, metadata)
+	header.Write(section.Bytes())
+
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	if copyBody {
+		// The source is positioned at the first file body
+		_, err := io.Copy(w, p.source)
+		return err
+	}
+	if err := p.writeFileContents(w, layout.files, xxtea); err != nil {
+		return err
+	}
+	_, err = w.Write(p.TrailingData)
+	return err
+}
+
+// bodyCopyable reports whether the encrypted file bodies and trailing data can
+// be copied from the source, which requires the package to be written with the
+// key it was read with and its files to be unchanged. The source has to start
+// with the encrypted sections that would be written, given in section, and is
+// left positioned after them. Otherwise, for example if the source is no
+// longer readable, the bodies have to be encrypted again.
+func (p *Package) bodyCopyable(key []byte, layout writeLayout, section []byte) bool {
+	if p.source == nil || !p.digestValid || !bytes.Equal(key, p.key) {
+		return false
+	}
+	if p.digest(layout.files) != p.contentDigest {
+		return false
+	}
+
+	if _, err := p.source.Seek(p.magicOffset, io.SeekStart); err != nil {
+		return false
+	}
+	prefix := make([]byte, len(section))
+	if _, err := io.ReadFull(p.source, prefix); err != nil {
+		return false
+	}
+	return bytes.Equal(prefix, section)
+}
+
+// writeCopy writes the plain sections of the package and copies the encrypted
+// sections from the source unchanged, which is valid as long as the key is
+func (p *Package) writeCopy(w io.Writer) error {
+	if p.source == nil {
+		return errors.New("package has no source to copy encrypted sections from")
+	}
+
+	layout := p.layout(p.key, WriteOptions{})
+	metadata := p.encodeMetadata(layout.metadata)
+	metadataHash := computeOszHash(metadata, len(layout.metadata)*3, 0xa7)
+
+	var header bytes.Buffer
+	p.encodeHeader(&header, layout, metadataHash, p.FileInfoHash, p.FullBodyHash, metadata)
+
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	if _, err := p.source.Seek(p.magicOffset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, p.source)
+	return err
+}
+
+// writeKey returns the key the package is written with. The key the package
+// was read with is kept, unless the metadata it is derived from changed. The
+// key is then derived from the metadata, so osu! is able to open the package.
+func (p *Package) writeKey() []byte {
+	metadata := p.originalMetadata()
+	if p.key != nil && maps.Equal(keyMetadata(metadata), p.keyMetadata) {
+		return p.key
+	}
+	if key, err := DeriveKeyFromMetadata(metadata); err == nil {
+		return key
+	}
+	return p.key
+}
+
+// encodeHeader encodes the header, hashes, metadata and filename mapping
+func (p *Package) encodeHeader(buf *bytes.Buffer, layout writeLayout, metadataHash, fileInfoHash, bodyHash, metadata []byte) {
+	buf.Write(fileIdentifier[:])
+	buf.WriteByte(p.Version)
+	buf.Write(layout.iv)
+	buf.Write(metadataHash)
+	buf.Write(fileInfoHash)
+	buf.Write(bodyHash)
+	buf.Write(metadata)
+	p.encodeFileNames(buf, layout.fileNames)
+}
+
+// encodeMetadata encodes the metadata section
+func (p *Package) encodeMetadata(metaTypes []MetaType) []byte {
+	var buf bytes.Buffer
+	writer := dotnet.NewWriter(&buf)
+	writer.WriteInt32(int32(len(metaTypes)))
+
+	for _, metaType := range metaTypes {
+		writer.WriteInt16(int16(metaType))
+		writer.WriteString(p.metadataValue(metaType))
+	}
+
+	return buf.Bytes()
+}
+
+// encodeFileNames encodes the filename to beatmap ID mapping
+func (p *Package) encodeFileNames(buf *bytes.Buffer, fileNames []string) {
+	writer := dotnet.NewWriter(buf)
+	writer.WriteInt32(int32(len(fileNames)))
+
+	for _, fileName := range fileNames {
+		name, _ := p.mappingName(fileName)
+		writer.WriteString(name)
+		writer.WriteInt32(p.FileNames[fileName])
+	}
+}
+
+// encodeFileInfo encodes and encrypts the file info section. Every value is
+// encrypted as its own chunk, the way parseFileInfo reads them back.
+func (p *Package) encodeFileInfo(layout writeLayout, xxtea *XXTEA) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := dotnet.NewWriter(NewXXTEAWriterWithCipher(&buf, xxtea))
+	writer.WriteInt32(int32(len(layout.files)))
+
+	var offset int32
+	for _, fileName := range layout.files {
+		fileInfo, ok := p.FileInfos[fileName]
+		if !ok {
+			return nil, fmt.Errorf("missing file info for %s", fileName)
+		}
+		if len(fileInfo.Hash) != 16 {
+			return nil, fmt.Errorf("invalid hash length for %s", fileName)
+		}
+
+		dateCreated, dateModified := fileInfo.DateCreated, fileInfo.DateModified
+		dateCreatedKind, dateModifiedKind := fileInfo.DateCreatedKind, fileInfo.DateModifiedKind
+		if layout.timestamp != nil {
+			dateCreated, dateModified = *layout.timestamp, *layout.timestamp
+			dateCreatedKind, dateModifiedKind = KindUtc, KindUtc
+		}
+
+		name, _ := fileInfo.rawName.original(fileName)
+		writer.WriteInt32(offset)
+		writer.WriteString(name)
+		writer.WriteBytes(fileInfo.Hash)
+		writer.WriteDateTime(dateCreated, dateCreatedKind)
+		writer.WriteDateTime(dateModified, dateModifiedKind)
+
+		offset += 4 + int32(len(p.Files[fileName]))
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeFileContents encrypts and writes the file contents
+func (p *Package) writeFileContents(w io.Writer, files []string, xxtea *XXTEA) error {
+	for _, fileName := range files {
+		content := p.Files[fileName]
+
+		writer, err := NewOsz2WriterWithCipher(w, len(content), xxtea)
+		if err != nil {
+			return err
+		}
+		if _, err := writer.Write(content); err != nil {
+			return err
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orderedKeys returns the keys of m in the given order, followed by
+// the keys that are missing from order in ascending order
+func orderedKeys[K cmp.Ordered, V any](m map[K]V, order []K) []K {
+	keys := make([]K, 0, len(m))
+	seen := make(map[K]bool, len(m))
+
+	for _, key := range order {
+		if _, ok := m[key]; ok && !seen[key] {
+			keys = append(keys, key)
+			seen[key] = true
+		}
+	}
+
+	var remaining []K
+	for key := range m {
+		if !seen[key] {
+			remaining = append(remaining, key)
+		}
+	}
+	slices.Sort(remaining)
+
+	return append(keys, remaining...)
+}
+
+// countingWriter counts the bytes written to the underlying writer
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+// Write writes to the underlying writer
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// SourceDateEpoch returns the time set in the SOURCE_DATE_EPOCH environment
+// variable, used by reproducible builds. If it is unset or invalid, the
+// Unix epoch is returned together with false.
+func SourceDateEpoch() (time.Time, bool) {
+	seconds, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64)
+	if err != nil {
+		return time.Unix(0, 0).UTC(), false
+	}
+	return time.Unix(seconds, 0).UTC(), true
+}
@@ -0,0 +1,556 @@
+package osz2
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestOsz2Writer re-encrypts every file of a test package and
+// compares the output with the bodies stored in the package
+func TestOsz2Writer(t *testing.T) {
+	data, pkg := readTestPackage(t, "tests/Karoo13 - Tic Tac Toe.osz2", false)
+
+	for fileName, fileInfo := range pkg.FileInfos {
+		content := pkg.Files[fileName]
+
+		var buf bytes.Buffer
+		writer, err := NewOsz2Writer(&buf, len(content), pkg.key)
+		if err != nil {
+			t.Fatalf("Failed to create writer for %s: %v", fileName, err)
+		}
+
+		// Write in uneven chunks to cross the 64-byte block boundaries
+		for offset := 0; offset < len(content); offset += 37 {
+			end := min(offset+37, len(content))
+			if _, err := writer.Write(content[offset:end]); err != nil {
+				t.Fatalf("Failed to write %s: %v", fileName, err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("Failed to close writer for %s: %v", fileName, err)
+		}
+
+		start := pkg.fileOffset + int64(fileInfo.Offset)
+		expected := data[start : start+int64(fileInfo.Size)]
+		if !bytes.Equal(buf.Bytes(), expected) {
+			t.Errorf("File %s: encrypted body differs from the package", fileName)
+		}
+	}
+}
+
+// TestOsz2WriterLength tests that the declared content length is enforced
+func TestOsz2WriterLength(t *testing.T) {
+	key := decodeHex(t, karooKey)
+
+	writer, err := NewOsz2Writer(io.Discard, 10, key)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if _, err := writer.Write(make([]byte, 11)); err == nil {
+		t.Error("Expected error when writing past the declared length")
+	}
+	if _, err := writer.Write(make([]byte, 5)); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if err := writer.Close(); err != io.ErrShortWrite {
+		t.Errorf("Expected io.ErrShortWrite when closing early, got %v", err)
+	}
+}
+
+// TestXXTEAWriter writes chunks of every size with an XXTEAWriter and
+// reads them back with an XXTEAReader using the same chunk sizes
+func TestXXTEAWriter(t *testing.T) {
+	key := bytesToUint32Array(decodeHex(t, karooKey))
+
+	var plain []byte
+	for size := 1; size <= 130; size++ {
+		plain = append(plain, bytes.Repeat([]byte{byte(size)}, size)...)
+	}
+	original := append([]byte{}, plain...)
+
+	var buf bytes.Buffer
+	writer := NewXXTEAWriter(&buf, key)
+	for offset, size := 0, 1; size <= 130; offset, size = offset+size, size+1 {
+		if _, err := writer.Write(plain[offset : offset+size]); err != nil {
+			t.Fatalf("Failed to write chunk of %d bytes: %v", size, err)
+		}
+	}
+	if !bytes.Equal(plain, original) {
+		t.Fatal("XXTEAWriter modified the caller's buffer")
+	}
+
+	reader := NewXXTEAReader(bytes.NewReader(buf.Bytes()), key)
+	for offset, size := 0, 1; size <= 130; offset, size = offset+size, size+1 {
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			t.Fatalf("Failed to read chunk of %d bytes: %v", size, err)
+		}
+		if !bytes.Equal(chunk, plain[offset:offset+size]) {
+			t.Errorf("Chunk of %d bytes did not round-trip", size)
+		}
+	}
+}
+
+// TestRoundTrip tests that writing an unmodified package reproduces the original bytes
+func TestRoundTrip(t *testing.T) {
+	for _, testFile := range []string{
+		"tests/Karoo13 - Tic Tac Toe.osz2",
+		"tests/nekodex - welcome to christmas.osz2",
+	} {
+		t.Run(testFile, func(t *testing.T) {
+			data, pkg := readTestPackage(t, testFile, false)
+
+			var buf bytes.Buffer
+			n, err := pkg.WriteTo(&buf)
+			if err != nil {
+				t.Fatalf("Failed to write package: %v", err)
+			}
+			if n != int64(buf.Len()) {
+				t.Errorf("WriteTo returned %d, but wrote %d bytes", n, buf.Len())
+			}
+
+			if !bytes.Equal(buf.Bytes(), data) {
+				t.Errorf("Written package differs from the original (%d vs %d bytes)", buf.Len(), len(data))
+			}
+		})
+	}
+}
+
+// TestRoundTripWithoutFiles tests that a package without files is read back
+// and written again unchanged
+func TestRoundTripWithoutFiles(t *testing.T) {
+	_, pkg := readTestPackage(t, "tests/Karoo13 - Tic Tac Toe.osz2", false)
+	for _, fileInfo := range pkg.Entries {
+		if err := pkg.RemoveFile(fileInfo.FileName); err != nil {
+			t.Fatalf("Failed to remove file: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+
+	empty, err := NewPackage(bytes.NewReader(buf.Bytes()), false)
+	if err != nil {
+		t.Fatalf("Failed to parse package without files: %v", err)
+	}
+	if len(empty.Entries) != 0 || len(empty.Files) != 0 {
+		t.Errorf("Expected no files, got %d entries", len(empty.Entries))
+	}
+	if empty.Metadata[Title] != pkg.Metadata[Title] {
+		t.Errorf("Expected title %q, got %q", pkg.Metadata[Title], empty.Metadata[Title])
+	}
+
+	var rewritten bytes.Buffer
+	if _, err := empty.WriteTo(&rewritten); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	if !bytes.Equal(rewritten.Bytes(), buf.Bytes()) {
+		t.Error("Package without files changed after a round trip")
+	}
+}
+
+// TestWriteMetadataOnly tests that a package read in metadata-only mode is
+// written by copying its encrypted sections
+func TestWriteMetadataOnly(t *testing.T) {
+	data, pkg := readTestPackage(t, "tests/nekodex - welcome to christmas.osz2", true)
+
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("Written package differs from the original")
+	}
+}
+
+// TestRoundTripWithKey tests that a package whose metadata no longer matches
+// its key keeps that key, and is written back unchanged
+func TestRoundTripWithKey(t *testing.T) {
+	key := DeriveKey("peppy", "-1")
+
+	// Change the creator without encrypting the package again
+	_, pkg := readTestPackage(t, "tests/nekodex - welcome to christmas.osz2", true)
+	pkg.Metadata[Creator] = "Lekuruu"
+
+	var altered bytes.Buffer
+	if err := pkg.writeCopy(&altered); err != nil {
+		t.Fatalf("Failed to write altered package: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		metadataOnly bool
+		option       Option
+	}{
+		{"key", false, WithKey(key)},
+		{"key metadata only", true, WithKey(key)},
+		{"key function", false, WithKeyFunc(func(map[MetaType]string) ([]byte, error) { return key, nil })},
+		{"key candidate", false, WithKeyCandidates(KeyCandidate{Creator: "peppy", BeatmapSetID: "-1"})},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pkg, err := NewPackage(bytes.NewReader(altered.Bytes()), test.metadataOnly, test.option)
+			if err != nil {
+				t.Fatalf("Failed to parse altered package: %v", err)
+			}
+
+			var buf bytes.Buffer
+			if _, err := pkg.WriteTo(&buf); err != nil {
+				t.Fatalf("Failed to write package: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), altered.Bytes()) {
+				t.Error("Written package differs from the original")
+			}
+			if _, err := NewPackage(bytes.NewReader(buf.Bytes()), false, WithKey(key)); err != nil {
+				t.Errorf("Failed to parse written package with its key: %v", err)
+			}
+		})
+	}
+}
+
+// TestEditMetadata tests changing metadata that does and does not affect the key
+func TestEditMetadata(t *testing.T) {
+	data, original := readTestPackage(t, "tests/nekodex - welcome to christmas.osz2", false)
+
+	tests := []struct {
+		name         string
+		metadataOnly bool
+		metaType     MetaType
+		value        string
+	}{
+		{"tags", true, Tags, "christmas winter"},
+		{"source", false, Source, "osu!"},
+		{"creator", true, Creator, "Lekuruu"},
+		{"beatmap set id", false, BeatmapSetID, "12345"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pkg, err := NewPackage(bytes.NewReader(data), test.metadataOnly)
+			if err != nil {
+				t.Fatalf("Failed to parse package: %v", err)
+			}
+			pkg.Metadata[test.metaType] = test.value
+
+			var buf bytes.Buffer
+			if _, err := pkg.WriteTo(&buf); err != nil {
+				t.Fatalf("Failed to write package: %v", err)
+			}
+
+			edited, err := NewPackage(bytes.NewReader(buf.Bytes()), false)
+			if err != nil {
+				t.Fatalf("Failed to parse edited package: %v", err)
+			}
+			if edited.Metadata[test.metaType] != test.value {
+				t.Errorf("Got %v %q, expected %q", test.metaType, edited.Metadata[test.metaType], test.value)
+			}
+
+			expectedKey := DeriveKey(edited.Metadata[Creator], edited.Metadata[BeatmapSetID])
+			if !bytes.Equal(edited.Key(), expectedKey) {
+				t.Errorf("Edited package uses key %x, expected %x", edited.Key(), expectedKey)
+			}
+
+			for fileName, content := range original.Files {
+				if !bytes.Equal(edited.Files[fileName], content) {
+					t.Errorf("File %s changed after editing metadata", fileName)
+				}
+			}
+
+			// Without a key change, everything after the header is copied
+			if test.metaType != Creator && test.metaType != BeatmapSetID {
+				tail := len(data) - int(original.magicOffset)
+				if !bytes.Equal(buf.Bytes()[buf.Len()-tail:], data[original.magicOffset:]) {
+					t.Error("Encrypted sections changed without a key change")
+				}
+			}
+		})
+	}
+}
+
+// sourceReader records reads from a package source, and fails them once closed
+type sourceReader struct {
+	*bytes.Reader
+	reads  int
+	closed bool
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	if s.closed {
+		return 0, os.ErrClosed
+	}
+	s.reads++
+	return s.Reader.Read(p)
+}
+
+// allocated returns the number of bytes allocated while running f
+func allocated(f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+// TestWriteUnchangedFiles tests that the files of a package read completely
+// are only encrypted again if they changed
+func TestWriteUnchangedFiles(t *testing.T) {
+	data := readTestFile(t, "tests/Karoo13 - Tic Tac Toe.osz2")
+	source := &sourceReader{Reader: bytes.NewReader(data)}
+	pkg, err := NewPackage(source, false)
+	if err != nil {
+		t.Fatalf("Failed to parse package: %v", err)
+	}
+	pkg.Metadata[Tags] = "tic tac toe"
+
+	// The bodies are copied from the source
+	source.reads = 0
+	var copied bytes.Buffer
+	if _, err := pkg.WriteTo(&copied); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	if source.reads == 0 {
+		t.Error("Expected the bodies to be copied from the source")
+	}
+	tail := len(data) - int(pkg.magicOffset)
+	if !bytes.Equal(copied.Bytes()[copied.Len()-tail:], data[pkg.magicOffset:]) {
+		t.Error("Encrypted sections changed without a change to the files")
+	}
+
+	// Neither copying nor encrypting the bodies buffers them
+	if n := allocated(func() { pkg.WriteTo(io.Discard) }); n > uint64(len(data)/4) {
+		t.Errorf("Copying the bodies allocated %d bytes for a %d byte package", n, len(data))
+	}
+	source.closed = true
+	if n := allocated(func() { pkg.WriteTo(io.Discard) }); n > uint64(len(data)/4) {
+		t.Errorf("Encrypting the bodies allocated %d bytes for a %d byte package", n, len(data))
+	}
+
+	// Without the source, they are encrypted again to the same bytes
+	var encrypted bytes.Buffer
+	if _, err := pkg.WriteTo(&encrypted); err != nil {
+		t.Fatalf("Failed to write package without its source: %v", err)
+	}
+	if !bytes.Equal(encrypted.Bytes(), copied.Bytes()) {
+		t.Error("Encrypted package differs from the copied one")
+	}
+
+	// Changed files are encrypted again without reading the source
+	source.closed = false
+	source.reads = 0
+	if err := pkg.ReplaceFile("hit0-0.png", []byte("replaced image")); err != nil {
+		t.Fatalf("Failed to replace file: %v", err)
+	}
+	var replaced bytes.Buffer
+	if _, err := pkg.WriteTo(&replaced); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	if source.reads != 0 {
+		t.Errorf("Expected no reads from the source, got %d", source.reads)
+	}
+	edited, err := NewPackage(bytes.NewReader(replaced.Bytes()), false)
+	if err != nil {
+		t.Fatalf("Failed to parse edited package: %v", err)
+	}
+	if string(edited.Files["hit0-0.png"]) != "replaced image" || edited.Metadata[Tags] != "tic tac toe" {
+		t.Error("Edited package lost the changes")
+	}
+	if err := edited.checkBodyHash(bytes.NewReader(replaced.Bytes()), int64(replaced.Len())); err != nil {
+		t.Errorf("Edited package has a wrong body hash: %v", err)
+	}
+}
+
+// TestEditFiles tests adding, replacing, renaming and removing files
+func TestEditFiles(t *testing.T) {
+	// Start in metadata-only mode, the files are loaded when needed
+	_, pkg := readTestPackage(t, "tests/Karoo13 - Tic Tac Toe.osz2", true)
+
+	osuFile := "Karoo13 - Tic Tac Toe (Karoo13) [overlay version].osu"
+	newHitsound := []byte("RIFF new hitsound")
+	replacement := []byte("replaced image")
+
+	if err := pkg.AddFile("soft-hitclap.wav", newHitsound); err != nil {
+		t.Fatalf("Failed to add file: %v", err)
+	}
+	if err := pkg.AddFile("soft-hitclap.wav", newHitsound); !errors.Is(err, ErrFileExists) {
+		t.Errorf("Expected ErrFileExists, got %v", err)
+	}
+	if err := pkg.ReplaceFile("hit0-0.png", replacement); err != nil {
+		t.Fatalf("Failed to replace file: %v", err)
+	}
+	if err := pkg.RenameFile(osuFile, "renamed.osu"); err != nil {
+		t.Fatalf("Failed to rename file: %v", err)
+	}
+	if err := pkg.RemoveFile("x.png"); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if err := pkg.RemoveFile("x.png"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound, got %v", err)
+	}
+	pkg.SetBeatmapID("renamed.osu", 42)
+
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+
+	edited, err := NewPackage(bytes.NewReader(buf.Bytes()), false)
+	if err != nil {
+		t.Fatalf("Failed to parse edited package: %v", err)
+	}
+
+	if !bytes.Equal(edited.Files["soft-hitclap.wav"], newHitsound) {
+		t.Error("Added file has wrong content")
+	}
+	if !bytes.Equal(edited.Files["hit0-0.png"], replacement) {
+		t.Error("Replaced file has wrong content")
+	}
+	if len(edited.FileInfos["hit0-0.png"].Hash) != 16 {
+		t.Errorf("Replaced file has a hash of %d bytes", len(edited.FileInfos["hit0-0.png"].Hash))
+	}
+	if _, exists := edited.Files["x.png"]; exists {
+		t.Error("Removed file is still in the package")
+	}
+	if _, exists := edited.Files[osuFile]; exists {
+		t.Error("Renamed file is still in the package under its old name")
+	}
+	if edited.FileNames["renamed.osu"] != 42 || edited.FileIDs[42] != "renamed.osu" {
+		t.Errorf("Beatmap id mapping was not updated: %v", edited.FileNames)
+	}
+	if _, exists := edited.FileIDs[2129375]; exists {
+		t.Error("Old beatmap id mapping is still in the package")
+	}
+	if len(edited.Files) != len(pkg.Files) {
+		t.Errorf("Expected %d files, got %d", len(pkg.Files), len(edited.Files))
+	}
+
+	// Offsets and sizes of the edited package match the in-memory model
+	for fileName, fileInfo := range edited.FileInfos {
+		expected := pkg.FileInfos[fileName]
+		if fileInfo.Offset != expected.Offset || fileInfo.Size != expected.Size {
+			t.Errorf("File %s: got offset %d and size %d, expected %d and %d",
+				fileName, fileInfo.Offset, fileInfo.Size, expected.Offset, expected.Size)
+		}
+		if !bytes.Equal(edited.Files[fileName], pkg.Files[fileName]) {
+			t.Errorf("File %s has wrong content", fileName)
+		}
+	}
+
+	// The reader does not check the body hash, so verify it here
+	body := buf.Bytes()[edited.fileOffset:]
+	if !bytes.Equal(computeOszHash(body, len(body)/2, 0x9f), edited.FullBodyHash) {
+		t.Error("Body hash of the edited package is wrong")
+	}
+}
+
+// TestDeterministic tests that packing the same inputs yields the same bytes
+func TestDeterministic(t *testing.T) {
+	files := map[string][]byte{
+		"audio.mp3":        bytes.Repeat([]byte{0xff, 0xfb}, 1000),
+		"bg.jpg":           []byte("background"),
+		"sb/sprite.png":    []byte("sprite"),
+		"artist - a.osu":   []byte("osu file format v14"),
+		"artist - b.osu":   []byte("osu file format v14\r\n"),
+		"empty.txt":        {},
+		"soft-hitclap.wav": []byte("RIFF"),
+	}
+	names := []string{"audio.mp3", "bg.jpg", "sb/sprite.png", "artist - a.osu", "artist - b.osu", "empty.txt", "soft-hitclap.wav"}
+
+	build := func(order []string) *Package {
+		pkg := NewEmptyPackage()
+		pkg.Metadata[Title] = "Title"
+		pkg.Metadata[Creator] = "Creator"
+		pkg.Metadata[BeatmapSetID] = "1"
+		for _, name := range order {
+			if err := pkg.AddFile(name, files[name]); err != nil {
+				t.Fatalf("Failed to add %s: %v", name, err)
+			}
+		}
+		pkg.SetBeatmapID("artist - b.osu", 2)
+		pkg.SetBeatmapID("artist - a.osu", 1)
+		return pkg
+	}
+
+	reversed := slices.Clone(names)
+	slices.Reverse(reversed)
+
+	timestamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	options := WriteOptions{Deterministic: true, Timestamp: timestamp}
+
+	var first, second bytes.Buffer
+	if _, err := build(names).Write(&first, options); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	if _, err := build(reversed).Write(&second, options); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Fatal("Deterministic output depends on the order files were added in")
+	}
+
+	pkg, err := NewPackage(bytes.NewReader(first.Bytes()), false)
+	if err != nil {
+		t.Fatalf("Failed to parse deterministic package: %v", err)
+	}
+	if !slices.IsSorted(pkg.entryNames()) {
+		t.Errorf("Files are not sorted: %v", pkg.entryNames())
+	}
+	for name, content := range files {
+		if !bytes.Equal(pkg.Files[name], content) {
+			t.Errorf("File %s has wrong content", name)
+		}
+		if !pkg.FileInfos[name].DateModified.Equal(timestamp) {
+			t.Errorf("File %s has timestamp %v, expected %v", name, pkg.FileInfos[name].DateModified, timestamp)
+		}
+	}
+
+	// Without an explicit timestamp, SOURCE_DATE_EPOCH is used
+	t.Setenv("SOURCE_DATE_EPOCH", "1577934245")
+	var third bytes.Buffer
+	if _, err := build(names).Write(&third, WriteOptions{Deterministic: true}); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	if !bytes.Equal(first.Bytes(), third.Bytes()) {
+		t.Error("SOURCE_DATE_EPOCH was not used as timestamp")
+	}
+}
+
+// TestFileInfoKinds tests that the DateTime kinds of file timestamps survive writing
+func TestFileInfoKinds(t *testing.T) {
+	_, pkg := readTestPackage(t, "tests/Karoo13 - Tic Tac Toe.osz2", false)
+
+	for name, fileInfo := range pkg.FileInfos {
+		if fileInfo.DateCreatedKind != KindUtc || fileInfo.DateModifiedKind != KindUtc {
+			t.Fatalf("File %s has kinds %s/%s, expected Utc", name, fileInfo.DateCreatedKind, fileInfo.DateModifiedKind)
+		}
+		fileInfo.DateCreatedKind = KindLocal
+		fileInfo.DateModifiedKind = KindUnspecified
+	}
+
+	var buf bytes.Buffer
+	if _, err := pkg.WriteTo(&buf); err != nil {
+		t.Fatalf("Failed to write package: %v", err)
+	}
+	written, err := NewPackage(bytes.NewReader(buf.Bytes()), false)
+	if err != nil {
+		t.Fatalf("Failed to parse written package: %v", err)
+	}
+
+	for name, fileInfo := range written.FileInfos {
+		original := pkg.FileInfos[name]
+		if fileInfo.DateCreatedKind != KindLocal || fileInfo.DateModifiedKind != KindUnspecified {
+			t.Errorf("File %s has kinds %s/%s after writing", name, fileInfo.DateCreatedKind, fileInfo.DateModifiedKind)
+		}
+		if !fileInfo.DateCreated.Equal(original.DateCreated) || !fileInfo.DateModified.Equal(original.DateModified) {
+			t.Errorf("File %s timestamps changed after writing", name)
+		}
+	}
+}
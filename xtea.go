@@ -4,18 +4,28 @@ import (
 	"encoding/binary"
 )
 
-// XTEA implements the Extended Tiny Encryption Algorithm
+// XTEA implements the Extended Tiny Encryption Algorithm.
+// An XTEA instance is immutable after creation and safe for concurrent use.
 type XTEA struct {
-	key           []uint32
+	schedule      [TEARounds][2]uint32
 	simpleCryptor *SimpleCryptor
 }
 
 // NewXTEA creates a new XTEA instance
 func NewXTEA(key []uint32) *XTEA {
-	return &XTEA{
-		key:           key,
-		simpleCryptor: NewSimpleCryptor(key),
+	x := &XTEA{simpleCryptor: NewSimpleCryptor(key)}
+
+	var k [4]uint32
+	copy(k[:], key)
+
+	// Precompute sum + key[...] for both halves of every round
+	var sum uint32
+	for i := range x.schedule {
+		x.schedule[i][0] = sum + k[sum&3]
+		sum += TEADelta
+		x.schedule[i][1] = sum + k[(sum>>11)&3]
 	}
+	return x
 }
 
 // Decrypt decrypts data using XTEA
@@ -23,6 +33,11 @@ func (x *XTEA) Decrypt(buffer []byte, start, count int) {
 	x.encryptDecrypt(buffer, start, count, false)
 }
 
+// Encrypt encrypts data using XTEA
+func (x *XTEA) Encrypt(buffer []byte, start, count int) {
+	x.encryptDecrypt(buffer, start, count, true)
+}
+
 // encryptDecrypt performs encryption or decryption
 func (x *XTEA) encryptDecrypt(buffer []byte, bufStart, count int, encrypt bool) {
 	fullWordCount := count / 8
@@ -58,27 +73,18 @@ func (x *XTEA) encryptDecrypt(buffer []byte, bufStart, count int, encrypt bool)
 
 // encryptWord encrypts a single 64-bit word (two 32-bit values)
 func (x *XTEA) encryptWord(v0, v1 uint32) (uint32, uint32) {
-	var sum uint32 = 0
-	for i := uint32(0); i < TEARounds; i++ {
-		v0 += (((v1 << 4) ^ (v1 >> 5)) + v1) ^ (sum + x.key[sum&3])
-		sum += TEADelta
-		v1 += (((v0 << 4) ^ (v0 >> 5)) + v0) ^ (sum + x.key[(sum>>11)&3])
+	for i := range x.schedule {
+		v0 += (((v1 << 4) ^ (v1 >> 5)) + v1) ^ x.schedule[i][0]
+		v1 += (((v0 << 4) ^ (v0 >> 5)) + v0) ^ x.schedule[i][1]
 	}
 	return v0, v1
 }
 
 // decryptWord decrypts a single 64-bit word (two 32-bit values)
 func (x *XTEA) decryptWord(v0, v1 uint32) (uint32, uint32) {
-	// Calculate sum with proper overflow handling
-	sum := uint32(0)
-	for i := uint32(0); i < TEARounds; i++ {
-		sum += TEADelta
-	}
-
-	for i := uint32(0); i < TEARounds; i++ {
-		v1 -= (((v0 << 4) ^ (v0 >> 5)) + v0) ^ (sum + x.key[(sum>>11)&3])
-		sum -= TEADelta
-		v0 -= (((v1 << 4) ^ (v1 >> 5)) + v1) ^ (sum + x.key[sum&3])
+	for i := len(x.schedule) - 1; i >= 0; i-- {
+		v1 -= (((v0 << 4) ^ (v0 >> 5)) + v0) ^ x.schedule[i][1]
+		v0 -= (((v1 << 4) ^ (v1 >> 5)) + v1) ^ x.schedule[i][0]
 	}
 	return v0, v1
 }
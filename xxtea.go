@@ -4,24 +4,47 @@ import (
 	"encoding/binary"
 )
 
-// XXTEA implements the Corrected Block TEA algorithm
+// XXTEA implements the Corrected Block TEA algorithm.
+// An XXTEA instance is immutable after creation and safe for concurrent use.
 type XXTEA struct {
-	key           []uint32
+	schedule      [xxteaMaxRounds]xxteaRound
 	simpleCryptor *SimpleCryptor
-	n             uint32
+}
+
+// xxteaRound holds the precomputed sum and key order of a single XXTEA round
+type xxteaRound struct {
+	sum uint32
+	key [4]uint32
 }
 
 const (
 	MaxWords = 16
 	MaxBytes = MaxWords * 4
+
+	// xxteaMaxRounds is the round count for the smallest block (two words)
+	xxteaMaxRounds = 6 + 52/2
 )
 
 // NewXXTEA creates a new XXTEA instance
 func NewXXTEA(key []uint32) *XXTEA {
-	return &XXTEA{
-		key:           key,
-		simpleCryptor: NewSimpleCryptor(key),
+	xx := &XXTEA{simpleCryptor: NewSimpleCryptor(key)}
+
+	var k [4]uint32
+	copy(k[:], key)
+
+	// The sum and the key word selected by (p&3)^e only depend
+	// on the round number, so they can be computed once per key
+	var sum uint32
+	for r := range xx.schedule {
+		sum += TEADelta
+		e := (sum >> 2) & 3
+		round := &xx.schedule[r]
+		round.sum = sum
+		for p := uint32(0); p < 4; p++ {
+			round.key[p] = k[p^e]
+		}
 	}
+	return xx
 }
 
 // Decrypt decrypts data using XXTEA
@@ -55,20 +78,20 @@ func (xx *XXTEA) encryptDecrypt(buffer []byte, bufStart, count int, encrypt bool
 
 	// Handle leftover bytes
 	leftoverStart := bufStart + fullWordCount*MaxBytes
-	xx.n = uint32(leftOver / 4)
+	n := uint32(leftOver / 4)
 
-	if xx.n > 1 {
+	if n > 1 {
 		if encrypt {
-			xx.encryptWords(buffer[leftoverStart : leftoverStart+int(xx.n)*4])
+			xx.encryptWords(buffer[leftoverStart:leftoverStart+int(n)*4], n)
 		} else {
-			xx.decryptWords(buffer[leftoverStart : leftoverStart+int(xx.n)*4])
+			xx.decryptWords(buffer[leftoverStart:leftoverStart+int(n)*4], n)
 		}
 
-		leftOver -= int(xx.n) * 4
+		leftOver -= int(n) * 4
 		if leftOver == 0 {
 			return
 		}
-		leftoverStart += int(xx.n) * 4
+		leftoverStart += int(n) * 4
 	}
 
 	// Handle remaining bytes with simple cryptor
@@ -82,163 +105,133 @@ func (xx *XXTEA) encryptDecrypt(buffer []byte, bufStart, count int, encrypt bool
 }
 
 // encryptWords encrypts a block of words using XXTEA
-func (xx *XXTEA) encryptWords(data []byte) {
-	if len(data) < int(xx.n)*4 {
+func (xx *XXTEA) encryptWords(data []byte, n uint32) {
+	if len(data) < int(n)*4 {
 		return
 	}
+	xx.encryptBlock(data, n)
+}
 
-	// Convert bytes to uint32 array
-	v := make([]uint32, xx.n)
-	for i := uint32(0); i < xx.n; i++ {
-		v[i] = binary.LittleEndian.Uint32(data[i*4:])
+// decryptWords decrypts a block of words using XXTEA
+func (xx *XXTEA) decryptWords(data []byte, n uint32) {
+	if len(data) < int(n)*4 {
+		return
+	}
+	xx.decryptBlock(data, n)
+}
+
+// encryptFixedWordArray encrypts a fixed block of MaxWords using XXTEA
+func (xx *XXTEA) encryptFixedWordArray(data []byte) {
+	if len(data) != MaxBytes {
+		return
 	}
 
-	var y, z, sum uint32
-	var p, e uint32
-	rounds := 6 + 52/xx.n
-	sum = 0
-	z = v[xx.n-1]
+	var v [MaxWords]uint32
+	for i := range v {
+		v[i] = binary.LittleEndian.Uint32(data[i*4:])
+	}
 
-	for rounds > 0 {
-		sum += TEADelta
-		e = (sum >> 2) & 3
-		for p = 0; p < xx.n-1; p++ {
-			y = v[p+1]
-			v[p] += (((z >> 5) ^ (y << 2)) + ((y >> 3) ^ (z << 4))) ^ ((sum ^ y) + (xx.key[(p&3)^e] ^ z))
+	z := v[MaxWords-1]
+	for r := 0; r < 6+52/MaxWords; r++ {
+		round := &xx.schedule[r]
+		for p := 0; p < MaxWords-1; p++ {
+			v[p] += xxteaMix(round.sum, v[p+1], z, round.key[p&3])
 			z = v[p]
 		}
-		y = v[0]
-		v[xx.n-1] += (((z >> 5) ^ (y << 2)) + ((y >> 3) ^ (z << 4))) ^ ((sum ^ y) + (xx.key[(p&3)^e] ^ z))
-		z = v[xx.n-1]
-		rounds--
+		v[MaxWords-1] += xxteaMix(round.sum, v[0], z, round.key[(MaxWords-1)&3])
+		z = v[MaxWords-1]
 	}
 
-	// Convert back to bytes
-	for i := uint32(0); i < xx.n; i++ {
+	for i := range v {
 		binary.LittleEndian.PutUint32(data[i*4:], v[i])
 	}
 }
 
-// decryptWords decrypts a block of words using XXTEA
-func (xx *XXTEA) decryptWords(data []byte) {
-	if len(data) < int(xx.n)*4 {
+// decryptFixedWordArray decrypts a fixed block of MaxWords using XXTEA
+func (xx *XXTEA) decryptFixedWordArray(data []byte) {
+	if len(data) != MaxBytes {
 		return
 	}
 
-	// Convert bytes to uint32 array
-	v := make([]uint32, xx.n)
-	for i := uint32(0); i < xx.n; i++ {
+	var v [MaxWords]uint32
+	for i := range v {
 		v[i] = binary.LittleEndian.Uint32(data[i*4:])
 	}
 
-	var y, z, sum uint32
-	var p, e uint32
-	rounds := 6 + 52/xx.n
-
-	// Calculate initial sum
-	sum = rounds * TEADelta
-	y = v[0]
-
-	for {
-		e = (sum >> 2) & 3
-		for p = xx.n - 1; p > 0; p-- {
-			z = v[p-1]
-			v[p] -= (((z >> 5) ^ (y << 2)) + ((y >> 3) ^ (z << 4))) ^ ((sum ^ y) + (xx.key[(p&3)^e] ^ z))
+	y := v[0]
+	for r := 6 + 52/MaxWords - 1; r >= 0; r-- {
+		round := &xx.schedule[r]
+		for p := MaxWords - 1; p > 0; p-- {
+			v[p] -= xxteaMix(round.sum, y, v[p-1], round.key[p&3])
 			y = v[p]
 		}
-		z = v[xx.n-1]
-		v[0] -= (((z >> 5) ^ (y << 2)) + ((y >> 3) ^ (z << 4))) ^ ((sum ^ y) + (xx.key[(p&3)^e] ^ z))
+		v[0] -= xxteaMix(round.sum, y, v[MaxWords-1], round.key[0])
 		y = v[0]
-
-		sum -= TEADelta
-		if sum == 0 {
-			break
-		}
 	}
 
-	// Convert back to bytes
-	for i := uint32(0); i < xx.n; i++ {
+	for i := range v {
 		binary.LittleEndian.PutUint32(data[i*4:], v[i])
 	}
 }
 
-// encryptFixedWordArray encrypts a fixed block of MaxWords using XXTEA
-func (xx *XXTEA) encryptFixedWordArray(data []byte) {
-	if len(data) != MaxBytes {
-		return
-	}
-
-	// Convert bytes to uint32 array
-	v := make([]uint32, MaxWords)
-	for i := 0; i < MaxWords; i++ {
+// encryptBlock encrypts n (2 to MaxWords-1) little-endian words in place
+func (xx *XXTEA) encryptBlock(data []byte, n uint32) {
+	var words [MaxWords]uint32
+	v := words[:n]
+	for i := range v {
 		v[i] = binary.LittleEndian.Uint32(data[i*4:])
 	}
 
-	var y, z, sum uint32
-	var p, e uint32
-	rounds := 6 + 52/MaxWords
-	sum = 0
-	z = v[MaxWords-1]
+	rounds := 6 + 52/n
+	z := v[n-1]
 
-	for rounds > 0 {
-		sum += TEADelta
-		e = (sum >> 2) & 3
-		for p = 0; p < MaxWords-1; p++ {
-			y = v[p+1]
-			v[p] += (((z >> 5) ^ (y << 2)) + ((y >> 3) ^ (z << 4))) ^ ((sum ^ y) + (xx.key[(p&3)^e] ^ z))
+	for r := uint32(0); r < rounds; r++ {
+		round := &xx.schedule[r]
+		var p uint32
+		for p = 0; p < n-1; p++ {
+			y := v[p+1]
+			v[p] += xxteaMix(round.sum, y, z, round.key[p&3])
 			z = v[p]
 		}
-		y = v[0]
-		v[MaxWords-1] += (((z >> 5) ^ (y << 2)) + ((y >> 3) ^ (z << 4))) ^ ((sum ^ y) + (xx.key[(p&3)^e] ^ z))
-		z = v[MaxWords-1]
-		rounds--
+		y := v[0]
+		v[n-1] += xxteaMix(round.sum, y, z, round.key[p&3])
+		z = v[n-1]
 	}
 
-	// Convert back to bytes
-	for i := 0; i < MaxWords; i++ {
+	for i := range v {
 		binary.LittleEndian.PutUint32(data[i*4:], v[i])
 	}
 }
 
-// decryptFixedWordArray decrypts a fixed block of MaxWords using XXTEA
-func (xx *XXTEA) decryptFixedWordArray(data []byte) {
-	if len(data) != MaxBytes {
-		return
-	}
-
-	// Convert bytes to uint32 array
-	v := make([]uint32, MaxWords)
-	for i := 0; i < MaxWords; i++ {
+// decryptBlock decrypts n (2 to MaxWords-1) little-endian words in place
+func (xx *XXTEA) decryptBlock(data []byte, n uint32) {
+	var words [MaxWords]uint32
+	v := words[:n]
+	for i := range v {
 		v[i] = binary.LittleEndian.Uint32(data[i*4:])
 	}
 
-	var y, z, sum uint32
-	var p, e uint32
-	rounds := 6 + 52/MaxWords
+	rounds := 6 + 52/n
+	y := v[0]
 
-	// Calculate initial sum
-	sum = uint32(rounds) * TEADelta
-	y = v[0]
-
-	for {
-		e = (sum >> 2) & 3
-		for p = MaxWords - 1; p > 0; p-- {
-			z = v[p-1]
-			v[p] -= (((z >> 5) ^ (y << 2)) + ((y >> 3) ^ (z << 4))) ^ ((sum ^ y) + (xx.key[(p&3)^e] ^ z))
+	for r := int(rounds) - 1; r >= 0; r-- {
+		round := &xx.schedule[r]
+		for p := n - 1; p > 0; p-- {
+			z := v[p-1]
+			v[p] -= xxteaMix(round.sum, y, z, round.key[p&3])
 			y = v[p]
 		}
-		z = v[MaxWords-1]
-		v[0] -= (((z >> 5) ^ (y << 2)) + ((y >> 3) ^ (z << 4))) ^ ((sum ^ y) + (xx.key[(p&3)^e] ^ z))
+		z := v[n-1]
+		v[0] -= xxteaMix(round.sum, y, z, round.key[0])
 		y = v[0]
-
-		sum -= TEADelta
-		if sum == 0 {
-			break
-		}
 	}
 
-	// Convert back to bytes
-	for i := 0; i < MaxWords; i++ {
+	for i := range v {
 		binary.LittleEndian.PutUint32(data[i*4:], v[i])
 	}
 }
+
+// xxteaMix is the XXTEA round function for a single word
+func xxteaMix(sum, y, z, key uint32) uint32 {
+	return (((z >> 5) ^ (y << 2)) + ((y >> 3) ^ (z << 4))) ^ ((sum ^ y) + (key ^ z))
+}
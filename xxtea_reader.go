@@ -4,7 +4,8 @@ import (
 	"io"
 )
 
-// XXTEAReader provides streaming XXTEA decryption matching C# XXTeaStream behavior
+// XXTEAReader provides streaming XXTEA decryption matching C# XXTeaStream behavior.
+// Like the reader it wraps, an XXTEAReader must not be used from multiple goroutines.
 type XXTEAReader struct {
 	reader io.Reader
 	xxtea  *XXTEA
@@ -12,9 +13,14 @@ type XXTEAReader struct {
 
 // NewXXTEAReader creates a new XXTEAReader
 func NewXXTEAReader(reader io.Reader, key []uint32) *XXTEAReader {
+	return NewXXTEAReaderWithCipher(reader, NewXXTEA(key))
+}
+
+// NewXXTEAReaderWithCipher creates a new XXTEAReader sharing an existing XXTEA instance
+func NewXXTEAReaderWithCipher(reader io.Reader, xxtea *XXTEA) *XXTEAReader {
 	return &XXTEAReader{
 		reader: reader,
-		xxtea:  NewXXTEA(key),
+		xxtea:  xxtea,
 	}
 }
 
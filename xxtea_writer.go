@@ -0,0 +1,46 @@
+package osz2
+
+import (
+	"io"
+)
+
+// XXTEAWriter provides streaming XXTEA encryption matching C# XXTeaStream.Write behavior.
+// Every call to Write is encrypted as its own chunk, so data written by an XXTEAWriter
+// has to be read back with the same sequence of read sizes by an XXTEAReader.
+type XXTEAWriter struct {
+	writer io.Writer
+	xxtea  *XXTEA
+	buffer []byte
+}
+
+// NewXXTEAWriter creates a new XXTEAWriter
+func NewXXTEAWriter(writer io.Writer, key []uint32) *XXTEAWriter {
+	return NewXXTEAWriterWithCipher(writer, NewXXTEA(key))
+}
+
+// NewXXTEAWriterWithCipher creates a new XXTEAWriter sharing an existing XXTEA instance
+func NewXXTEAWriterWithCipher(writer io.Writer, xxtea *XXTEA) *XXTEAWriter {
+	return &XXTEAWriter{
+		writer: writer,
+		xxtea:  xxtea,
+	}
+}
+
+// Write encrypts p and writes it to the underlying writer.
+// The contents of p are left untouched.
+func (x *XXTEAWriter) Write(p []byte) (int, error) {
+	if cap(x.buffer) < len(p) {
+		x.buffer = make([]byte, len(p))
+	}
+	buffer := x.buffer[:len(p)]
+	copy(buffer, p)
+
+	x.xxtea.Encrypt(buffer, 0, len(buffer))
+	return x.writer.Write(buffer)
+}
+
+// WriteByte encrypts and writes a single byte
+func (x *XXTEAWriter) WriteByte(b byte) error {
+	_, err := x.Write([]byte{b})
+	return err
+}